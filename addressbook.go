@@ -5,6 +5,7 @@ import (
 	"github.com/emersion/go-vcard"
 	"github.com/emersion/go-webdav/carddav"
 	"golang.org/x/sync/errgroup"
+	"slices"
 	"src.agwa.name/go-xmpp"
 	"strings"
 
@@ -15,10 +16,44 @@ type addressBook struct {
 	changed   bool
 	syncToken string
 	entries   map[string]carddav.AddressObject // map from path -> object
+	avatars   map[string]*avatar               // map from path -> photo, for entries with a photo
+	name      string                           // display name of the address book
+	roster    Roster                           // the roster last pushed to the user, or nil
+	dirty     bool                             // whether the state has changed since it was saved
+	order     []string                         // the order of paths, if not sorted (see merge)
+	bookNames map[string]string                // map from path -> name of the address book it came from, if not name (see merge)
+}
+
+// rosterOptions controls how address book contacts are added to the roster
+type rosterOptions struct {
+	defaultGroup  string // group for contacts without categories, or ""
+	bookGroup     bool   // whether to add a group named after the address book
+	allNumbers    bool   // whether to add every SMS-capable number of a contact, rather than only the first
+	defaultPrefix string // country code for numbers in national format, or ""
+	twoWay        bool   // whether to write changes made to the roster back to the address book
+}
+
+func (service *Service) getRosterOptions() rosterOptions {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	options := service.rosterOptions
+	options.defaultPrefix = service.defaultPrefix
+	return options
+}
+
+// getRosterOptionsFor returns the roster options for the user's address book,
+// whose national numbers may be in a different country than default_prefix
+func (service *Service) getRosterOptionsFor(userJID xmpp.Address) rosterOptions {
+	options := service.getRosterOptions()
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	if prefix, exists := service.rosterPrefixes[userJID]; exists {
+		options.defaultPrefix = prefix
+	}
+	return options
 }
 
 func (addrbook *addressBook) download(ctx context.Context, client *carddav.Client) error {
-	log.Printf("Sync token = %q", addrbook.syncToken)
 	response, err := client.SyncCollection("", &carddav.SyncQuery{
 		DataRequest: carddav.AddressDataRequest{AllProp: true},
 		SyncToken:   addrbook.syncToken,
@@ -31,19 +66,35 @@ func (addrbook *addressBook) download(ctx context.Context, client *carddav.Clien
 	}
 
 	if addrbook.entries == nil {
+		addrbook.name = findAddressBookName(client)
 		addrbook.entries = make(map[string]carddav.AddressObject)
+		addrbook.avatars = make(map[string]*avatar)
 		addrbook.changed = true
 	}
+	updatedCards := make(map[string]vcard.Card)
+	for _, updatedObject := range response.Updated {
+		updatedCards[updatedObject.Path] = updatedObject.Card
+	}
+	updatedAvatars := loadVcardPhotos(ctx, updatedCards)
 	for _, updatedObject := range response.Updated {
 		log.Printf("Adding %#v to address book", updatedObject)
 		addrbook.entries[updatedObject.Path] = updatedObject
 		addrbook.changed = true
+
+		delete(addrbook.avatars, updatedObject.Path)
+		if avatar := updatedAvatars[updatedObject.Path]; avatar != nil {
+			addrbook.avatars[updatedObject.Path] = avatar
+		}
 	}
 	for _, deletedPath := range response.Deleted {
 		log.Printf("Deleting %s from address book", deletedPath)
 		delete(addrbook.entries, deletedPath)
+		delete(addrbook.avatars, deletedPath)
 		addrbook.changed = true
 	}
+	if addrbook.changed || addrbook.syncToken != response.SyncToken {
+		addrbook.dirty = true
+	}
 	addrbook.syncToken = response.SyncToken
 	return nil
 }
@@ -68,38 +119,238 @@ func (addrbook *addressBook) downloadObjects(ctx context.Context, client *cardda
 	return group.Wait()
 }
 
-func (addrbook *addressBook) makeRoster(domain string) Roster {
+// findAddressBookName returns the display name of the address book at the
+// client's endpoint, or "" if it can't be determined
+func findAddressBookName(client *carddav.Client) string {
+	addressBooks, err := client.FindAddressBooks("")
+	if err != nil {
+		log.Printf("Unable to determine address book name: %s", err)
+		return ""
+	}
+	for _, addressBook := range addressBooks {
+		if addressBook.Name != "" {
+			return addressBook.Name
+		}
+	}
+	return ""
+}
+
+func (addrbook *addressBook) sortedPaths() []string {
+	if addrbook.order != nil {
+		return addrbook.order
+	}
+	paths := make([]string, 0, len(addrbook.entries))
+	for path := range addrbook.entries {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// makeRoster returns a roster item for each SMS-capable number in the address
+// book.  If several entries contain the same number, the one with the lowest
+// path is used.  If an entry has more than one number in the roster, each
+// item's name is followed by a label for the number.
+func (addrbook *addressBook) makeRoster(domain string, options rosterOptions) Roster {
 	roster := make(Roster)
-	for _, object := range addrbook.entries {
-		name := object.Card.PreferredValue(vcard.FieldFormattedName)
-		cellNumber := getVcardCellNumber(object.Card)
-		if name != "" && cellNumber != "" {
-			// TODO: deterministically handle the case where we have two vcards with the same phone number
-			jid := xmpp.Address{LocalPart: cellNumber, DomainPart: domain}
-			roster[jid] = RosterItem{
-				Name: name,
+	for _, path := range addrbook.sortedPaths() {
+		card := addrbook.entries[path].Card
+		name := card.PreferredValue(vcard.FieldFormattedName)
+		if name == "" {
+			continue
+		}
+		var numbers []vcardNumber
+		for _, number := range smsNumbers(vcardNumbers(card, options.defaultPrefix), options.allNumbers) {
+			if _, taken := roster[xmpp.Address{LocalPart: number.number, DomainPart: domain}]; !taken {
+				numbers = append(numbers, number)
+			}
+		}
+		groups := addrbook.rosterGroups(path, card, options)
+		labels := numberLabels(numbers)
+		for i, number := range numbers {
+			item := RosterItem{Name: name, Groups: groups}
+			if len(numbers) > 1 {
+				item.Name += " (" + labels[i] + ")"
 			}
+			roster[xmpp.Address{LocalPart: number.number, DomainPart: domain}] = item
 		}
 	}
 	return roster
 }
 
-func getVcardCellNumber(card vcard.Card) string {
+// rosterGroups returns the sorted roster groups for a vCard, which are its
+// CATEGORIES, or the default group if it has none, plus the name of the
+// address book containing it if enabled
+func (addrbook *addressBook) rosterGroups(path string, card vcard.Card, options rosterOptions) []string {
+	var groups []string
+	for _, value := range card.Values(vcard.FieldCategories) {
+		for _, category := range strings.Split(value, ",") {
+			if category = strings.TrimSpace(category); category != "" && !slices.Contains(groups, category) {
+				groups = append(groups, category)
+			}
+		}
+	}
+	if len(groups) == 0 && options.defaultGroup != "" {
+		groups = append(groups, options.defaultGroup)
+	}
+	bookName := addrbook.name
+	if name, ok := addrbook.bookNames[path]; ok {
+		bookName = name
+	}
+	if options.bookGroup && bookName != "" && !slices.Contains(groups, bookName) {
+		groups = append(groups, bookName)
+	}
+	slices.Sort(groups)
+	return groups
+}
+
+// makeContacts returns a map from each phone number in the address book to
+// the entry containing it.  If several entries contain the same number, the one
+// with the lowest path is used.
+func (addrbook *addressBook) makeContacts(defaultPrefix string) map[string]*contact {
+	contacts := make(map[string]*contact)
+	for _, path := range addrbook.sortedPaths() {
+		card := addrbook.entries[path].Card
+		contact := &contact{card: card, avatar: addrbook.avatars[path]}
+		for _, number := range vcardNumbers(card, defaultPrefix) {
+			contact.numbers = append(contact.numbers, contactNumber{number: number.number, types: number.types})
+			if _, exists := contacts[number.number]; !exists {
+				contacts[number.number] = contact
+			}
+		}
+	}
+	return contacts
+}
+
+// vcardNumber is a phone number from a vCard's TEL field
+type vcardNumber struct {
+	number string // E.164
+	types  []string
+	label  string // e.g. "mobile" or "work", or "" if unknown
+}
+
+// isMobile reports whether the number is explicitly a mobile number
+func (number *vcardNumber) isMobile() bool {
+	for _, t := range number.types {
+		switch strings.ToLower(t) {
+		case "cell", "mobile", "iphone", "text":
+			return true
+		}
+	}
+	return false
+}
+
+// isUntyped reports whether the number has no type that says what kind of
+// number it is
+func (number *vcardNumber) isUntyped() bool {
+	for _, t := range number.types {
+		switch strings.ToLower(t) {
+		case "voice", "pref":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// vcardNumbers returns the valid phone numbers in the vCard, with preferred
+// numbers first
+func vcardNumbers(card vcard.Card, defaultPrefix string) []vcardNumber {
+	var numbers, preferred []vcardNumber
 	for _, field := range card[vcard.FieldTelephone] {
-		if field.Params.HasType(vcard.TypeCell) {
-			return cleanupVcardPhoneNumber(field.Value)
+		phoneNumber, ok := normalizeVcardPhoneNumber(field.Value, defaultPrefix)
+		if !ok {
+			continue
+		}
+		number := vcardNumber{
+			number: phoneNumber,
+			types:  field.Params.Types(),
+			label:  vcardFieldLabel(card, field),
+		}
+		if field.Params.HasType("pref") || field.Params.Get(vcard.ParamPreferred) != "" {
+			preferred = append(preferred, number)
+		} else {
+			numbers = append(numbers, number)
+		}
+	}
+	return append(preferred, numbers...)
+}
+
+// smsNumbers returns the numbers which can receive SMS: those which are
+// explicitly mobile numbers, or if there are none, those without a type.
+// Only the first is returned unless all is true.
+func smsNumbers(numbers []vcardNumber, all bool) []vcardNumber {
+	var mobile, untyped []vcardNumber
+	for _, number := range numbers {
+		if number.isMobile() {
+			mobile = append(mobile, number)
+		} else if number.isUntyped() {
+			untyped = append(untyped, number)
+		}
+	}
+	if len(mobile) == 0 {
+		mobile = untyped
+	}
+	if !all && len(mobile) > 1 {
+		mobile = mobile[:1]
+	}
+	return mobile
+}
+
+// vcardFieldLabel returns a label for the field, which is the custom label
+// that Apple Contacts stores in an X-ABLabel field of the same group if
+// present, or the field's type otherwise
+func vcardFieldLabel(card vcard.Card, field *vcard.Field) string {
+	if field.Group != "" {
+		for _, labelField := range card["X-ABLABEL"] {
+			if labelField.Group == field.Group {
+				label := strings.TrimPrefix(labelField.Value, "_$!<")
+				label = strings.TrimSuffix(label, ">!$_")
+				return strings.ToLower(label)
+			}
+		}
+	}
+	for _, t := range field.Params.Types() {
+		switch t = strings.ToLower(t); t {
+		case "voice", "pref", "internet":
+		case "cell":
+			return "mobile"
+		case "iphone":
+			return "iPhone"
+		default:
+			return t
 		}
 	}
 	return ""
 }
 
-func cleanupVcardPhoneNumber(num string) string {
-	num = strings.TrimPrefix(num, "011")
-	ret := "+"
-	for _, c := range num {
-		if c >= '0' && c <= '9' {
-			ret += string(c)
+// numberLabels returns a label for each number which distinguishes it from
+// the contact's other numbers.  The formatted number is used when a number has
+// no label or shares its label with another number.
+func numberLabels(numbers []vcardNumber) []string {
+	count := make(map[string]int)
+	for _, number := range numbers {
+		count[strings.ToLower(number.label)]++
+	}
+	labels := make([]string, len(numbers))
+	for i, number := range numbers {
+		if number.label == "" || count[strings.ToLower(number.label)] > 1 {
+			labels[i] = formatPhoneNumber(number.number)
+		} else {
+			labels[i] = number.label
 		}
 	}
-	return ret
+	return labels
+}
+
+// normalizeVcardPhoneNumber converts a phone number from a vCard, which may be
+// a tel: URI or in national format with punctuation and an extension, to E.164.
+// National numbers are parsed according to the conventions of the country
+// whose calling code is defaultPrefix (see parsePhoneNumber).
+func normalizeVcardPhoneNumber(num string, defaultPrefix string) (string, bool) {
+	if i := strings.IndexByte(num, ','); i >= 0 {
+		num = num[:i] // remove a pause and the digits dialed after it
+	}
+	phoneNumber, err := parsePhoneNumber(num, defaultPrefix)
+	return phoneNumber, err == nil
 }
@@ -0,0 +1,237 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-vcard"
+	"github.com/emersion/go-webdav/carddav"
+	"src.agwa.name/go-xmpp"
+)
+
+// addressBookState is the state of a user's address book synchronization,
+// saved in the state directory so that synchronization can resume
+// incrementally after a restart
+type addressBookState struct {
+	SourceHash string                     `json:"source_hash"` // see sourceHash
+	PushToken  string                     `json:"push_token"`  // kept across restarts so that existing WebDAV-Push subscriptions keep working
+	SyncToken  string                     `json:"sync_token"`
+	Name       string                     `json:"name"`
+	Entries    []addressBookStateEntry    `json:"entries"`
+	Roster     map[string]rosterStateItem `json:"roster"` // map from JID local part -> item, as last pushed to the user's roster
+}
+
+type addressBookStateEntry struct {
+	Path    string       `json:"path"`
+	ETag    string       `json:"etag"`
+	ModTime time.Time    `json:"mod_time"`
+	Vcard   string       `json:"vcard"`
+	Photo   *avatarState `json:"photo,omitempty"`
+}
+
+type avatarState struct {
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+}
+
+type rosterStateItem struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+func (service *Service) getStateDir() string {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	return service.stateDir
+}
+
+// addressBookStatePath returns the file in which the user's address book
+// state is saved, or "" if the state_dir option isn't set
+func (service *Service) addressBookStatePath(userJID xmpp.Address) string {
+	return addressBookStateFile(service.getStateDir(), userJID)
+}
+
+func addressBookStateFile(stateDir string, userJID xmpp.Address) string {
+	if stateDir == "" {
+		return ""
+	}
+	return filepath.Join(stateDir, "addressbooks", url.PathEscape(userJID.String())+".json")
+}
+
+// loadPushToken returns the push token saved in the address book state at
+// path, or "" if there isn't one for the source URL
+func loadPushToken(path string, sourceURL string) string {
+	if path == "" {
+		return ""
+	}
+	stateJSON, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var state addressBookState
+	if err := json.Unmarshal(stateJSON, &state); err != nil || state.SourceHash != sourceHash(sourceURL) {
+		return ""
+	}
+	return state.PushToken
+}
+
+// sourceHash identifies the source URL which the state was saved for.  The
+// URL itself isn't saved because it may contain credentials.
+func sourceHash(sourceURL string) string {
+	hash := sha256.Sum256([]byte(sourceURL))
+	return hex.EncodeToString(hash[:])
+}
+
+// loadAddressBook loads the address book state saved at path.  If there is no
+// saved state, or it's for a different source URL, an empty address book is
+// returned.
+func loadAddressBook(path string, sourceURL string, domain string) (*addressBook, error) {
+	stateJSON, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return new(addressBook), nil
+	} else if err != nil {
+		return nil, err
+	}
+	var state addressBookState
+	if err := json.Unmarshal(stateJSON, &state); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if state.SourceHash != sourceHash(sourceURL) {
+		return new(addressBook), nil
+	}
+
+	addrbook := &addressBook{
+		changed:   true,
+		syncToken: state.SyncToken,
+		name:      state.Name,
+		entries:   make(map[string]carddav.AddressObject),
+		avatars:   make(map[string]*avatar),
+		roster:    make(Roster),
+	}
+	for _, entry := range state.Entries {
+		card, err := vcard.NewDecoder(strings.NewReader(entry.Vcard)).Decode()
+		if err != nil {
+			return nil, fmt.Errorf("%s: vCard %s: %w", path, entry.Path, err)
+		}
+		addrbook.entries[entry.Path] = carddav.AddressObject{
+			Path:    entry.Path,
+			ModTime: entry.ModTime,
+			ETag:    entry.ETag,
+			Card:    card,
+		}
+		if entry.Photo != nil {
+			hash := sha1.Sum(entry.Photo.Data)
+			addrbook.avatars[entry.Path] = &avatar{
+				contentType: entry.Photo.ContentType,
+				data:        entry.Photo.Data,
+				hash:        hex.EncodeToString(hash[:]),
+				width:       entry.Photo.Width,
+				height:      entry.Photo.Height,
+			}
+		}
+	}
+	for localPart, item := range state.Roster {
+		addrbook.roster[xmpp.Address{LocalPart: localPart, DomainPart: domain}] = RosterItem{
+			Name:   item.Name,
+			Groups: item.Groups,
+		}
+	}
+	return addrbook, nil
+}
+
+// save atomically writes the address book state to path
+func (addrbook *addressBook) save(path string, sourceURL string, pushToken string) error {
+	state := addressBookState{
+		SourceHash: sourceHash(sourceURL),
+		PushToken:  pushToken,
+		SyncToken:  addrbook.syncToken,
+		Name:       addrbook.name,
+		Roster:     make(map[string]rosterStateItem),
+	}
+	for _, entryPath := range addrbook.sortedPaths() {
+		object := addrbook.entries[entryPath]
+		var vcardText bytes.Buffer
+		if err := vcard.NewEncoder(&vcardText).Encode(object.Card); err != nil {
+			return fmt.Errorf("vCard %s: %w", entryPath, err)
+		}
+		entry := addressBookStateEntry{
+			Path:    entryPath,
+			ETag:    object.ETag,
+			ModTime: object.ModTime,
+			Vcard:   vcardText.String(),
+		}
+		if avatar := addrbook.avatars[entryPath]; avatar != nil {
+			entry.Photo = &avatarState{
+				ContentType: avatar.contentType,
+				Data:        avatar.data,
+				Width:       avatar.width,
+				Height:      avatar.height,
+			}
+		}
+		state.Entries = append(state.Entries, entry)
+	}
+	for jid, item := range addrbook.roster {
+		state.Roster[jid.LocalPart] = rosterStateItem{Name: item.Name, Groups: item.Groups}
+	}
+
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := tempFile.Write(stateJSON); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), path)
+}
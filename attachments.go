@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"errors"
+	"mime"
+	"strings"
+	"time"
+
+	"src.agwa.name/go-xmpp"
+)
+
+// attachment is an inbound MMS attachment.  Only the URL is known unless the
+// media was re-hosted.
+type attachment struct {
+	url         string
+	contentType string
+	filename    string
+	size        int64
+}
+
+func (service *Service) combineMMSEnabled() bool {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	return service.combineMMS
+}
+
+// sendXMPPMMS delivers the text and attachments of an MMS.  If combine_mms is
+// enabled, they are sent as a single message which describes each attachment
+// using both XEP-0447 (Stateless File Sharing) and XEP-0066 (Out of Band Data),
+// and whose body contains the text followed by the attachment URLs for clients
+// which support neither.  Otherwise, each attachment is sent as its own message.
+func (service *Service) sendXMPPMMS(from xmpp.Address, to xmpp.Address, body string, attachments []attachment) error {
+	if !service.combineMMSEnabled() {
+		if body != "" {
+			if err := service.sendXMPPChat(from, to, body); err != nil {
+				return err
+			}
+		}
+		for _, attachment := range attachments {
+			if err := service.sendXMPPMediaURL(from, to, attachment.url); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	xmppMessage := messageStanza{
+		Header: xmpp.Header{
+			From: &from,
+			To:   &to,
+			ID:   xmpp.RandomID(),
+		},
+		Type:              xmpp.CHAT,
+		chatStateElements: service.activeChatState(from, to),
+		Nick:              service.senderNick(from, to),
+	}
+	lines := make([]string, 0, len(attachments)+1)
+	if body != "" {
+		lines = append(lines, body)
+	}
+	for _, attachment := range attachments {
+		lines = append(lines, attachment.url)
+		xmppMessage.OutOfBandData = append(xmppMessage.OutOfBandData, outOfBandData{URL: attachment.url})
+		xmppMessage.FileSharing = append(xmppMessage.FileSharing, fileSharing{
+			Disposition: "inline",
+			File: fileMetadata{
+				MediaType: attachment.mediaType(),
+				Name:      attachment.filename,
+				Size:      attachment.size,
+			},
+			Sources: []urlData{{Target: attachment.url}},
+		})
+	}
+	xmppMessage.Body = strings.Join(lines, "\n")
+
+	if !service.sendWithin(5*time.Second, xmppMessage) {
+		return errors.New("Timed out when sending XMPP message with attachments")
+	}
+	service.recordInbound(from, to, &historyEntry{id: xmppMessage.ID, body: body, attachment: body == ""})
+	return nil
+}
+
+func (attachment *attachment) mediaType() string {
+	mediaType, _, err := mime.ParseMediaType(attachment.contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// xmppMessageAttachments returns the URLs of the message's attachments, which
+// may be described using XEP-0066 (Out of Band Data), XEP-0447 (Stateless File
+// Sharing), or XEP-0385 (Stateless Inline Media Sharing).  Clients often
+// describe the same attachment using more than one of these, so duplicate URLs
+// are removed.
+func xmppMessageAttachments(message *messageStanza) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	add := func(url string) {
+		if isHTTPURL(url) && !seen[url] {
+			urls = append(urls, url)
+			seen[url] = true
+		}
+	}
+	for _, fileSharing := range message.FileSharing {
+		for _, source := range fileSharing.Sources {
+			if isHTTPURL(source.Target) {
+				add(source.Target)
+				break
+			}
+		}
+	}
+	for _, reference := range message.References {
+		if reference.MediaSharing == nil {
+			continue
+		}
+		for _, source := range reference.MediaSharing.Sources {
+			if isHTTPURL(source.URI) {
+				add(source.URI)
+				break
+			}
+		}
+	}
+	for _, oob := range message.OutOfBandData {
+		add(oob.URL)
+	}
+	return urls
+}
+
+func isHTTPURL(url string) bool {
+	return strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")
+}
+
+// stripAttachmentURLs removes the lines of body which consist only of an
+// attachment URL, since clients include them as a fallback for recipients
+// which can't display attachments
+func stripAttachmentURLs(body string, urls []string) string {
+	if len(urls) == 0 {
+		return body
+	}
+	isURL := make(map[string]bool)
+	for _, url := range urls {
+		isURL[url] = true
+	}
+	var lines []string
+	for _, line := range strings.Split(body, "\n") {
+		if !isURL[strings.TrimSpace(line)] {
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
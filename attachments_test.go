@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"encoding/xml"
+	"slices"
+	"testing"
+)
+
+func TestXMPPMessageAttachments(t *testing.T) {
+	tests := []struct {
+		name   string
+		stanza string
+		urls   []string
+		body   string
+	}{
+		{
+			name:   "no attachments",
+			stanza: `<message xmlns="jabber:component:accept"><body>hello</body></message>`,
+			body:   "hello",
+		},
+		{
+			name: "one out-of-band attachment",
+			stanza: `<message xmlns="jabber:component:accept"><body>https://example.com/a.jpg</body>` +
+				`<x xmlns="jabber:x:oob"><url>https://example.com/a.jpg</url></x></message>`,
+			urls: []string{"https://example.com/a.jpg"},
+		},
+		{
+			name: "two out-of-band attachments",
+			stanza: "<message xmlns=\"jabber:component:accept\"><body>look\nhttps://example.com/a.jpg\nhttps://example.com/b.jpg</body>" +
+				`<x xmlns="jabber:x:oob"><url>https://example.com/a.jpg</url></x>` +
+				`<x xmlns="jabber:x:oob"><url>https://example.com/b.jpg</url></x></message>`,
+			urls: []string{"https://example.com/a.jpg", "https://example.com/b.jpg"},
+			body: "look",
+		},
+		{
+			name: "same attachment described twice",
+			stanza: `<message xmlns="jabber:component:accept"><body>https://example.com/a.jpg</body>` +
+				`<file-sharing xmlns="urn:xmpp:sfs:0" disposition="inline"><file xmlns="urn:xmpp:file:metadata:0"><media-type>image/jpeg</media-type></file>` +
+				`<sources><url-data xmlns="http://jabber.org/protocol/url-data" target="https://example.com/a.jpg"/></sources></file-sharing>` +
+				`<x xmlns="jabber:x:oob"><url>https://example.com/a.jpg</url></x></message>`,
+			urls: []string{"https://example.com/a.jpg"},
+		},
+		{
+			name: "non-HTTP URL",
+			stanza: `<message xmlns="jabber:component:accept"><body>hi</body>` +
+				`<x xmlns="jabber:x:oob"><url>ftp://example.com/a.jpg</url></x></message>`,
+			body: "hi",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var message messageStanza
+			if err := xml.Unmarshal([]byte(test.stanza), &message); err != nil {
+				t.Fatal(err)
+			}
+			urls := xmppMessageAttachments(&message)
+			if !slices.Equal(urls, test.urls) {
+				t.Errorf("xmppMessageAttachments() = %q, want %q", urls, test.urls)
+			}
+			if body := stripAttachmentURLs(message.Body, urls); body != test.body {
+				t.Errorf("stripAttachmentURLs() = %q, want %q", body, test.body)
+			}
+		})
+	}
+}
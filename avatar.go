@@ -0,0 +1,310 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"mime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-vcard"
+	"golang.org/x/sync/errgroup"
+	"src.agwa.name/go-xmpp"
+)
+
+const (
+	avatarDataNS     = "urn:xmpp:avatar:data"
+	avatarMetadataNS = "urn:xmpp:avatar:metadata"
+)
+
+// maxAvatarSize is the largest avatar sent to clients.  Larger photos are
+// recompressed, since XEP-0153 avatars are sent inline in the vCard.
+const maxAvatarSize = 64 * 1024
+
+const (
+	maxPhotoSize      = 10 * 1024 * 1024 // largest photo downloaded from a vCard's PHOTO URL
+	photoTimeout      = 30 * time.Second
+	maxPhotoDownloads = 10 // number of photos downloaded at once
+)
+
+type avatar struct {
+	contentType string
+	data        []byte
+	hash        string // hex-encoded SHA-1 of data, which identifies the avatar in XEP-0084 and XEP-0153
+	width       int
+	height      int
+}
+
+func makeAvatar(contentType string, data []byte) (*avatar, error) {
+	file := &mediaFile{contentType: contentType, data: data}
+	contentType = mediaContentType(file)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("photo has unsupported type %s", contentType)
+	}
+	config, _, configErr := image.DecodeConfig(bytes.NewReader(data))
+	if len(data) > maxAvatarSize {
+		img, err := decodeImage(data, contentType)
+		if err != nil {
+			return nil, fmt.Errorf("photo is larger than %d bytes and can't be decoded: %w", maxAvatarSize, err)
+		}
+		if data, err = compressImage(img, maxAvatarSize); err != nil {
+			return nil, err
+		}
+		contentType = "image/jpeg"
+		config, _, configErr = image.DecodeConfig(bytes.NewReader(data))
+	}
+	hash := sha1.Sum(data)
+	avatar := &avatar{
+		contentType: contentType,
+		data:        data,
+		hash:        hex.EncodeToString(hash[:]),
+	}
+	if configErr == nil {
+		avatar.width, avatar.height = config.Width, config.Height
+	}
+	return avatar, nil
+}
+
+// loadVcardPhotos returns the photos of the vCards, keyed by the same path
+// as cards, downloading up to maxPhotoDownloads of them at once.  Photos which
+// can't be loaded are logged and left out.
+func loadVcardPhotos(ctx context.Context, cards map[string]vcard.Card) map[string]*avatar {
+	var (
+		mu      sync.Mutex
+		group   errgroup.Group
+		avatars = make(map[string]*avatar)
+	)
+	group.SetLimit(maxPhotoDownloads)
+	for path, card := range cards {
+		group.Go(func() error {
+			avatar, err := loadVcardPhoto(ctx, card)
+			if err != nil {
+				log.Printf("Ignoring photo of %s: %s", path, err)
+			} else if avatar != nil {
+				mu.Lock()
+				avatars[path] = avatar
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	group.Wait()
+	return avatars
+}
+
+// loadVcardPhoto returns the vCard's photo, which may be inline (base64 in
+// vCard 3, or a data: URI in vCard 4) or an HTTP URL to download.  It returns
+// nil if the vCard has no photo.  Since address books can be shared or
+// written by others, URLs are only fetched if they resolve to a public
+// address, like outbound media.
+func loadVcardPhoto(ctx context.Context, card vcard.Card) (*avatar, error) {
+	field := card.Preferred(vcard.FieldPhoto)
+	if field == nil || field.Value == "" {
+		return nil, nil
+	}
+	value := strings.TrimSpace(field.Value)
+
+	if data, isDataURI := strings.CutPrefix(value, "data:"); isDataURI {
+		mediaType, encoded, found := strings.Cut(data, ",")
+		if !found || !strings.HasSuffix(mediaType, ";base64") {
+			return nil, errors.New("photo has malformed data URI")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("photo has malformed data URI: %w", err)
+		}
+		return makeAvatar(strings.TrimSuffix(mediaType, ";base64"), decoded)
+	}
+
+	if isHTTPURL(value) {
+		ctx, cancel := context.WithTimeout(ctx, photoTimeout)
+		defer cancel()
+		file, err := downloadMedia(ctx, publicHTTPClient, nil, value, maxPhotoSize)
+		if err != nil {
+			return nil, fmt.Errorf("unable to download photo: %w", err)
+		}
+		return makeAvatar(file.contentType, file.data)
+	}
+
+	switch strings.ToLower(field.Params.Get("ENCODING")) {
+	case "b", "base64":
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+		if err != nil {
+			return nil, fmt.Errorf("photo has malformed base64: %w", err)
+		}
+		contentType := ""
+		if t := field.Params.Get(vcard.ParamType); t != "" {
+			contentType = mime.TypeByExtension("." + strings.ToLower(t))
+		}
+		return makeAvatar(contentType, decoded)
+	}
+	return nil, errors.New("photo has unsupported format")
+}
+
+// contactPresence returns the presence of a contact JID, which includes the
+// hash of the contact's avatar (XEP-0153) if the user has a photo for them
+func (service *Service) contactPresence(from xmpp.Address, to xmpp.Address) *presenceStanza {
+	presence := &presenceStanza{
+		Header: xmpp.Header{
+			From: &from,
+			To:   &to,
+		},
+	}
+	if info, err := service.lookupContactInfo(to, from); err == nil {
+		hash := ""
+		if info.avatar != nil {
+			hash = info.avatar.hash
+		}
+		presence.VCardUpdate = &vcardUpdate{Photo: &hash}
+	}
+	return presence
+}
+
+// publishAvatarChanges notifies the user of contacts whose avatar has changed
+// by sending presence with the new hash (XEP-0153) and an avatar metadata
+// notification (XEP-0084)
+func (service *Service) publishAvatarChanges(userJID xmpp.Address, oldContacts map[string]*contact, newContacts map[string]*contact) {
+	changed := make(map[string]bool)
+	for number, newContact := range newContacts {
+		if avatarHash(oldContacts[number]) != avatarHash(newContact) {
+			changed[number] = true
+		}
+	}
+	for number, oldContact := range oldContacts {
+		if _, exists := newContacts[number]; !exists && oldContact.avatar != nil {
+			changed[number] = true
+		}
+	}
+
+	for number := range changed {
+		from := xmpp.Address{LocalPart: number, DomainPart: service.xmppParams.Domain}
+		if !service.sendWithin(5*time.Second, service.contactPresence(from, userJID)) {
+			log.Printf("Timed out when sending avatar update for %s to %s", number, userJID)
+			return
+		}
+		event := &messageStanza{
+			Header: xmpp.Header{
+				From: &from,
+				To:   &userJID,
+				ID:   xmpp.RandomID(),
+			},
+			Type: headlineMessage,
+			PubSubEvent: &pubSubEvent{
+				Items: &pubSubItems{
+					Node:  avatarMetadataNS,
+					Items: []pubSubItem{avatarMetadataItem(newContacts[number].avatarOrNil())},
+				},
+			},
+		}
+		if !service.sendWithin(5*time.Second, event) {
+			log.Printf("Timed out when sending avatar update for %s to %s", number, userJID)
+			return
+		}
+	}
+}
+
+func avatarHash(contact *contact) string {
+	if contact == nil || contact.avatar == nil {
+		return ""
+	}
+	return contact.avatar.hash
+}
+
+func (contact *contact) avatarOrNil() *avatar {
+	if contact == nil {
+		return nil
+	}
+	return contact.avatar
+}
+
+// avatarMetadataItem returns the XEP-0084 metadata for the avatar, which is
+// empty if there is no avatar
+func avatarMetadataItem(avatar *avatar) pubSubItem {
+	if avatar == nil {
+		return pubSubItem{AvatarMetadata: &avatarMetadata{}}
+	}
+	return pubSubItem{
+		ID: avatar.hash,
+		AvatarMetadata: &avatarMetadata{
+			Info: []avatarInfo{{
+				Bytes:  len(avatar.data),
+				ID:     avatar.hash,
+				Type:   avatar.contentType,
+				Width:  avatar.width,
+				Height: avatar.height,
+			}},
+		},
+	}
+}
+
+// receiveXMPPPubSub answers requests for the XEP-0084 avatar of a contact JID
+func (service *Service) receiveXMPPPubSub(ctx context.Context, iq *iqStanza) error {
+	if iq.From == nil || iq.To == nil {
+		return errors.New("Received malformed XMPP iq: From and To not set")
+	}
+	if iq.Type != "get" {
+		return nil
+	}
+	if _, userExists := service.lookupUser(*iq.From.Bare()); !userExists {
+		return service.sendXMPPIq(makeIqError(iq, "auth", "forbidden"))
+	}
+	if iq.To.LocalPart == "" || iq.PubSub.Items == nil {
+		return service.sendXMPPIq(makeIqError(iq, "cancel", "feature-not-implemented"))
+	}
+	info, err := service.lookupContactInfo(*iq.From, *iq.To)
+	if err != nil {
+		return service.sendXMPPIq(makeIqError(iq, "cancel", "item-not-found"))
+	}
+
+	items := &pubSubItems{Node: iq.PubSub.Items.Node}
+	switch iq.PubSub.Items.Node {
+	case avatarMetadataNS:
+		items.Items = []pubSubItem{avatarMetadataItem(info.avatar)}
+	case avatarDataNS:
+		if info.avatar == nil {
+			return service.sendXMPPIq(makeIqError(iq, "cancel", "item-not-found"))
+		}
+		items.Items = []pubSubItem{{ID: info.avatar.hash, AvatarData: &avatarData{Data: base64.StdEncoding.EncodeToString(info.avatar.data)}}}
+	default:
+		return service.sendXMPPIq(makeIqError(iq, "cancel", "item-not-found"))
+	}
+
+	reply := makeIqReply(iq, "result")
+	reply.PubSub = &pubSub{Items: items}
+	return service.sendXMPPIq(reply)
+}
@@ -0,0 +1,248 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-vcard"
+	"src.agwa.name/go-xmpp"
+)
+
+const (
+	nickNS = "http://jabber.org/protocol/nick"
+
+	callerNameTimeout    = 30 * time.Second
+	callerNameWait       = 3 * time.Second     // how long to hold a message for a lookup of the sender's caller name
+	callerNameCacheTTL   = 30 * 24 * time.Hour // lookups cost money, and caller names rarely change
+	callerNameFailureTTL = 24 * time.Hour      // how long to wait before retrying a failed lookup
+)
+
+type callerNameEntry struct {
+	Name   string    `json:"name"` // "" if the number has no caller name
+	Looked time.Time `json:"looked"`
+	Failed bool      `json:"failed,omitempty"` // the last lookup failed, so Name, if any, is from an earlier lookup
+}
+
+func (entry callerNameEntry) expired() bool {
+	ttl := callerNameCacheTTL
+	if entry.Failed {
+		ttl = callerNameFailureTTL
+	}
+	return time.Since(entry.Looked) >= ttl
+}
+
+// callerNameCache remembers the results of caller name lookups, including
+// unsuccessful ones, so that each number is only looked up once per
+// callerNameCacheTTL.  If the state_dir option is set, the cache is saved in
+// it so that it survives restarts.
+type callerNameCache struct {
+	mu      sync.Mutex
+	entries map[string]callerNameEntry // map from phone number -> entry
+	path    string                     // file which entries were loaded from, or ""
+	pending map[string]chan struct{}   // map from phone number being looked up -> channel closed when the lookup finishes
+}
+
+// prune removes expired entries, other than those being looked up again, so
+// that the cache only holds numbers which have sent messages recently
+func (cache *callerNameCache) prune() {
+	for phoneNumber, entry := range cache.entries {
+		if _, pending := cache.pending[phoneNumber]; entry.expired() && !pending {
+			delete(cache.entries, phoneNumber)
+		}
+	}
+}
+
+func (cache *callerNameCache) load(path string) {
+	if cache.entries != nil && cache.path == path {
+		return
+	}
+	cache.entries = make(map[string]callerNameEntry)
+	cache.path = path
+	if path == "" {
+		return
+	}
+	cacheJSON, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	} else if err != nil {
+		log.Printf("Ignoring caller name cache: %s", err)
+		return
+	}
+	if err := json.Unmarshal(cacheJSON, &cache.entries); err != nil {
+		log.Printf("Ignoring caller name cache: %s: %s", path, err)
+		cache.entries = make(map[string]callerNameEntry)
+	}
+	cache.prune()
+}
+
+func (cache *callerNameCache) save() error {
+	cacheJSON, err := json.Marshal(cache.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cache.path), 0700); err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(cache.path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := tempFile.Write(cacheJSON); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), cache.path)
+}
+
+func (service *Service) callerNameCachePath() string {
+	stateDir := service.getStateDir()
+	if stateDir == "" {
+		return ""
+	}
+	return filepath.Join(stateDir, "caller_names.json")
+}
+
+// cachedCallerName returns the caller name of the phone number, if it has
+// been looked up and it has one
+func (service *Service) cachedCallerName(phoneNumber string) string {
+	cache := &service.callerNames
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.load(service.callerNameCachePath())
+	return cache.entries[phoneNumber].Name
+}
+
+// startCallerNameLookup looks up the caller name of the phone number in the
+// background using the provider, if it supports caller name lookups, the
+// number isn't in the user's address book, and the number isn't in the cache
+// already.  The result is added to the cache, from which senderNick retrieves
+// it.  startCallerNameLookup returns a channel which is closed when the
+// lookup finishes, or nil if there is nothing to look up.
+func (service *Service) startCallerNameLookup(userAddress xmpp.Address, provider Provider, phoneNumber string) <-chan struct{} {
+	looker, ok := provider.(CallerNameLooker)
+	if !ok {
+		return nil
+	}
+	if user, exists := service.lookupRosterUser(userAddress); exists {
+		if _, exists := user.lookupContact(phoneNumber); exists {
+			return nil
+		}
+	}
+	cache := &service.callerNames
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.load(service.callerNameCachePath())
+	if done, pending := cache.pending[phoneNumber]; pending {
+		return done
+	}
+	if entry, cached := cache.entries[phoneNumber]; cached && !entry.expired() {
+		return nil
+	}
+	if cache.pending == nil {
+		cache.pending = make(map[string]chan struct{})
+	}
+	done := make(chan struct{})
+	cache.pending[phoneNumber] = done
+	go service.lookupCallerName(looker, phoneNumber)
+	return done
+}
+
+// waitForCallerName starts looking up the caller name of the phone number
+// (see startCallerNameLookup) and waits up to callerNameWait for the lookup
+// to finish, so that even the first message from a number carries the
+// sender's caller name
+func (service *Service) waitForCallerName(userAddress xmpp.Address, provider Provider, phoneNumber string) {
+	done := service.startCallerNameLookup(userAddress, provider, phoneNumber)
+	if done == nil {
+		return
+	}
+	timer := time.NewTimer(callerNameWait)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+}
+
+func (service *Service) lookupCallerName(looker CallerNameLooker, phoneNumber string) {
+	ctx, cancel := context.WithTimeout(context.Background(), callerNameTimeout)
+	defer cancel()
+	name, err := looker.LookupCallerName(ctx, phoneNumber)
+	entry := callerNameEntry{Name: name, Looked: time.Now()}
+	if err != nil {
+		log.Printf("Error looking up caller name of %s: %s", phoneNumber, err)
+		entry = callerNameEntry{Looked: time.Now(), Failed: true}
+	}
+
+	cache := &service.callerNames
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	close(cache.pending[phoneNumber])
+	delete(cache.pending, phoneNumber)
+	if entry.Failed {
+		entry.Name = cache.entries[phoneNumber].Name
+	}
+	cache.entries[phoneNumber] = entry
+	cache.prune()
+	if cache.path != "" {
+		if err := cache.save(); err != nil {
+			log.Printf("Error saving caller name cache: %s", err)
+		}
+	}
+}
+
+// senderNick returns the XEP-0172 nickname to attach to messages from a
+// contact: their name in the user's address book, or else their caller name,
+// if known
+func (service *Service) senderNick(from xmpp.Address, to xmpp.Address) string {
+	phoneNumber, err := service.canonPhoneNumber(from.LocalPart)
+	if err != nil {
+		return ""
+	}
+	if user, exists := service.lookupRosterUser(*to.Bare()); exists {
+		if contact, exists := user.lookupContact(phoneNumber); exists {
+			if name := contact.card.PreferredValue(vcard.FieldFormattedName); name != "" {
+				return name
+			}
+		}
+	}
+	return service.cachedCallerName(phoneNumber)
+}
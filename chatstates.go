@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"src.agwa.name/go-xmpp"
+)
+
+const chatStatesNS = "http://jabber.org/protocol/chatstates"
+
+// supportsChatStates reports whether the user's provider can send and receive
+// typing indicators.  Chat states (XEP-0085) are advertised and sent only if
+// so, since clients keep sending them to any contact which sends them.
+func (service *Service) supportsChatStates(userAddress xmpp.Address) bool {
+	user, exists := service.lookupUser(*userAddress.Bare())
+	if !exists {
+		return false
+	}
+	_, ok := user.provider.(TypingNotifier)
+	return ok
+}
+
+// activeChatState returns the chat state to include in a message with content
+// sent from a contact JID to the user
+func (service *Service) activeChatState(from xmpp.Address, to xmpp.Address) chatStateElements {
+	if from.LocalPart == "" || !service.supportsChatStates(to) {
+		return chatStateElements{}
+	}
+	return makeChatState(chatStateActive)
+}
+
+// receiveXMPPChatState forwards a standalone chat state notification to the
+// provider as a typing indicator, if the provider supports them
+func (service *Service) receiveXMPPChatState(xmppMessage *messageStanza) error {
+	if xmppMessage.chatState() == "" {
+		return nil
+	}
+	user, userExists := service.lookupUser(*xmppMessage.From.Bare())
+	if !userExists {
+		return nil
+	}
+	typingNotifier, ok := user.provider.(TypingNotifier)
+	if !ok {
+		return nil
+	}
+	toPhoneNumber, err := service.canonPhoneNumber(xmppMessage.To.LocalPart)
+	if err != nil {
+		return nil
+	}
+	typing := xmppMessage.chatState() == chatStateComposing
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := typingNotifier.SendTyping(ctx, user.phoneNumber, toPhoneNumber, typing); err != nil {
+			log.Printf("Unable to send typing indicator from %s to %s: %s", user.phoneNumber, toPhoneNumber, err)
+		}
+	}()
+	return nil
+}
+
+// ReceiveTyping is called by providers when the sender starts or stops typing
+// a message to the recipient.  It is delivered to the user as a chat state.
+func (service *Service) ReceiveTyping(from string, to string, typing bool) error {
+	address, _, known := service.userForPhoneNumber(to)
+	if !known {
+		return errors.New("Unknown phone number " + to)
+	}
+	chatState := chatStatePaused
+	if typing {
+		chatState = chatStateComposing
+	}
+	xmppMessage := messageStanza{
+		Header: xmpp.Header{
+			From: &xmpp.Address{
+				LocalPart:  service.friendlyPhoneNumber(from),
+				DomainPart: service.xmppParams.Domain,
+			},
+			To: &address,
+		},
+		Type:              xmpp.CHAT,
+		chatStateElements: makeChatState(chatState),
+	}
+	if !service.sendWithin(5*time.Second, xmppMessage) {
+		return errors.New("Timed out when sending XMPP chat state")
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"src.agwa.name/go-xmpp"
+	"src.agwa.name/sms-over-xmpp/config"
+)
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CheckConfig checks the configuration for problems which would otherwise only
+// surface at runtime, and returns all of them.
+func CheckConfig(config *config.Config) []error {
+	var errs []error
+	errorf := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if config.XMPPServer == "" {
+		errorf("xmpp_server option is not set")
+	}
+	if config.XMPPDomain == "" {
+		errorf("xmpp_domain option is not set")
+	}
+	if config.XMPPSecret == "" {
+		errorf("xmpp_secret option is not set")
+	}
+	if config.DefaultPrefix != "" {
+		if err := checkDefaultPrefix(config.DefaultPrefix); err != nil {
+			errorf("default_prefix option is invalid: %s", err)
+		}
+	}
+	if config.PublicURL != "" {
+		if err := checkHTTPURL(config.PublicURL); err != nil {
+			errorf("public_url option is invalid: %s", err)
+		}
+	}
+
+	if config.MediaUploadService != "" {
+		if _, err := xmpp.ParseAddress(config.MediaUploadService); err != nil {
+			errorf("media_upload_service option is invalid: %s", err)
+		}
+	}
+	if config.MediaDir != "" {
+		if config.PublicURL == "" {
+			errorf("media_dir option requires the public_url option to be set")
+		}
+		if info, err := os.Stat(config.MediaDir); err != nil {
+			errorf("media_dir option is invalid: %s", err)
+		} else if !info.IsDir() {
+			errorf("media_dir option is invalid: %s is not a directory", config.MediaDir)
+		}
+		if _, exists := config.Providers["media"]; exists {
+			errorf("A provider cannot be named media when the media_dir option is set")
+		}
+	}
+	if config.MediaRetention < 0 {
+		errorf("media_retention option must not be negative")
+	}
+	if config.SendDelay < 0 {
+		errorf("send_delay option must not be negative")
+	}
+	if config.StateDir != "" {
+		if info, err := os.Stat(config.StateDir); err != nil {
+			errorf("state_dir option is invalid: %s", err)
+		} else if !info.IsDir() {
+			errorf("state_dir option is invalid: %s is not a directory", config.StateDir)
+		}
+	}
+
+	if len(config.Providers) == 0 {
+		errorf("No providers are configured")
+	}
+	for _, providerName := range sortedKeys(config.Providers) {
+		providerConfig := config.Providers[providerName]
+		if _, err := MakeProvider(providerConfig.Type, nil, providerConfig.Params); err != nil {
+			errorf("Provider %s: %s", providerName, err)
+		}
+	}
+
+	usersByPhoneNumber := make(map[string][]string)
+	for _, userJID := range sortedKeys(config.Users) {
+		userConfig := config.Users[userJID]
+		if err := checkBareJID(userJID); err != nil {
+			errorf("User %s has malformed JID: %s", userJID, err)
+		}
+		if _, providerExists := config.Providers[userConfig.Provider]; !providerExists {
+			errorf("User %s refers to non-existent provider %s", userJID, userConfig.Provider)
+		}
+		if err := validateE164(userConfig.PhoneNumber); err != nil {
+			errorf("User %s has phone number %q which is not in E.164 format (e.g. +12125551212): %s", userJID, userConfig.PhoneNumber, err)
+		}
+		usersByPhoneNumber[userConfig.PhoneNumber] = append(usersByPhoneNumber[userConfig.PhoneNumber], userJID)
+	}
+	for _, phoneNumber := range sortedKeys(usersByPhoneNumber) {
+		if userJIDs := usersByPhoneNumber[phoneNumber]; len(userJIDs) > 1 {
+			errorf("Phone number %s belongs to more than one user: %s", phoneNumber, strings.Join(userJIDs, ", "))
+		}
+	}
+
+	for _, userJID := range sortedKeys(config.Rosters) {
+		if err := checkBareJID(userJID); err != nil {
+			errorf("Roster for %s has malformed JID: %s", userJID, err)
+		}
+		if _, err := newContactSource(config.Rosters[userJID]); err != nil {
+			errorf("Roster for %s has invalid URL: %s", userJID, err)
+		}
+	}
+	if config.RosterInterval <= 0 {
+		errorf("roster_interval option must be a positive integer")
+	}
+	for _, userJID := range sortedKeys(config.RosterIntervals) {
+		if err := checkBareJID(userJID); err != nil {
+			errorf("Roster interval for %s has malformed JID: %s", userJID, err)
+		}
+		if config.RosterIntervals[userJID] <= 0 {
+			errorf("Roster interval for %s must be a positive integer", userJID)
+		}
+	}
+	for _, userJID := range sortedKeys(config.RosterPrefixes) {
+		if err := checkBareJID(userJID); err != nil {
+			errorf("Roster prefix for %s has malformed JID: %s", userJID, err)
+		}
+		if err := checkDefaultPrefix(config.RosterPrefixes[userJID]); err != nil {
+			errorf("Roster prefix for %s is invalid: %s", userJID, err)
+		}
+	}
+	if config.SharedRoster != "" {
+		if _, err := newContactSource(config.SharedRoster); err != nil {
+			errorf("Shared roster has invalid URL: %s", err)
+		}
+	}
+
+	for _, adminJID := range config.Admins {
+		if err := checkBareJID(adminJID); err != nil {
+			errorf("Admin %s has malformed JID: %s", adminJID, err)
+		}
+	}
+
+	return errs
+}
+
+func checkBareJID(jid string) error {
+	address, err := xmpp.ParseAddress(jid)
+	if err != nil {
+		return err
+	}
+	if address != *address.Bare() {
+		return errors.New("must not contain a resource")
+	}
+	return nil
+}
+
+func checkHTTPURL(rawURL string) error {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return errors.New("is not a valid URL") // don't return err since it contains the URL, which may contain a password
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return errors.New("must start with http:// or https://")
+	}
+	if parsedURL.Host == "" {
+		return errors.New("lacks a hostname")
+	}
+	return nil
+}
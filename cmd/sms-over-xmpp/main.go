@@ -30,9 +30,14 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"src.agwa.name/go-listener"
 	_ "src.agwa.name/go-listener/tls"
@@ -43,12 +48,73 @@ import (
 	_ "src.agwa.name/sms-over-xmpp/providers/voipms"
 )
 
+func checkConfigMain(args []string) {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	configPath := flags.String("config", "", "Path to config directory or YAML config file")
+	flags.Parse(args)
+
+	if *configPath == "" {
+		log.Fatal("-config flag not specified")
+	}
+
+	config, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if errs := smsxmpp.CheckConfig(config); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+	fmt.Println("Configuration OK")
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func providerSchemaMain(args []string) {
+	typeNames := args
+	if len(typeNames) == 0 {
+		typeNames = smsxmpp.ProviderTypes()
+	}
+	for _, typeName := range typeNames {
+		schema, exists := smsxmpp.GetProviderSchema(typeName)
+		if !exists {
+			log.Fatalf("%s is not a known provider type (known types are %s)", typeName, strings.Join(smsxmpp.ProviderTypes(), ", "))
+		}
+		fmt.Printf("## `%s`\n\n%s\n\n", typeName, schema.Description)
+		fmt.Println("| Parameter | Type | Required | Secret | Description |")
+		fmt.Println("| --------- | ---- | -------- | ------ | ----------- |")
+		for _, param := range schema.Params {
+			fmt.Printf("| `%s` | %s | %s | %s | %s |\n", param.Name, param.Type, yesNo(param.Required), yesNo(param.Secret), param.Description)
+		}
+		fmt.Println()
+	}
+}
+
 func main() {
+	if len(os.Args) >= 2 {
+		switch os.Args[1] {
+		case "check-config":
+			checkConfigMain(os.Args[2:])
+			return
+		case "provider-schema":
+			providerSchemaMain(os.Args[2:])
+			return
+		}
+	}
+
 	var flags struct {
 		config string
 		listen []string
 	}
-	flag.StringVar(&flags.config, "config", "", "Path to config directory")
+	flag.StringVar(&flags.config, "config", "", "Path to config directory or YAML config file")
 	flag.Func("listen", "Socket to listen on (repeatable)", func(arg string) error {
 		flags.listen = append(flags.listen, arg)
 		return nil
@@ -62,7 +128,11 @@ func main() {
 		log.Fatal("-listen flag not specified")
 	}
 
-	config, err := config.FromDirectory(flags.config)
+	loadConfig := func() (*config.Config, error) {
+		return config.Load(flags.config)
+	}
+
+	config, err := loadConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -77,6 +147,7 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	service.SetConfigLoader(loadConfig)
 
 	httpServer := http.Server{
 		Handler: service.HTTPHandler(),
@@ -88,11 +159,29 @@ func main() {
 		}(l)
 	}
 
+	go func() {
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		for range sighup {
+			if err := service.Reload(); err != nil {
+				log.Printf("Reloading configuration failed (keeping existing configuration): %s", err)
+			} else {
+				log.Printf("Reloaded configuration")
+			}
+		}
+	}()
+
 	go func() {
 		if err := service.RunAddressBookUpdater(context.Background()); err != nil {
 			log.Fatal(err)
 		}
 	}()
 
+	go func() {
+		if err := service.RunMediaCleanup(context.Background()); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
 	log.Fatal(service.RunXMPPComponent(context.Background()))
 }
@@ -0,0 +1,281 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"src.agwa.name/go-xmpp"
+)
+
+// XEP-0050 (Ad-Hoc Commands) for administrators listed in the admins file
+
+const commandsNS = "http://jabber.org/protocol/commands"
+
+type formValues map[string][]string
+
+func (values formValues) get(name string) string {
+	if len(values[name]) == 0 {
+		return ""
+	}
+	return values[name][0]
+}
+
+type adminCommand struct {
+	node    string
+	name    string
+	form    func(*Service) *dataForm                   // nil if the command takes no input
+	execute func(*Service, formValues) (string, error) // returns a note to show to the admin
+}
+
+var adminCommands = []adminCommand{
+	{
+		node:    "add-user",
+		name:    "Add User",
+		form:    (*Service).addUserForm,
+		execute: (*Service).executeAddUser,
+	},
+	{
+		node:    "remove-user",
+		name:    "Remove User",
+		form:    (*Service).removeUserForm,
+		execute: (*Service).executeRemoveUser,
+	},
+	{
+		node:    "reload",
+		name:    "Reload Configuration",
+		execute: (*Service).executeReload,
+	},
+	{
+		node:    "provider-stats",
+		name:    "Show Provider Statistics",
+		execute: (*Service).executeProviderStats,
+	},
+	{
+		node:    "broadcast",
+		name:    "Broadcast Notice to All Users",
+		form:    (*Service).broadcastForm,
+		execute: (*Service).executeBroadcast,
+	},
+}
+
+func findAdminCommand(node string) (adminCommand, bool) {
+	for _, command := range adminCommands {
+		if command.node == node {
+			return command, true
+		}
+	}
+	return adminCommand{}, false
+}
+
+func (service *Service) receiveXMPPCommand(ctx context.Context, iq *iqStanza) error {
+	if iq.From == nil || iq.To == nil {
+		return errors.New("Received malformed XMPP iq: From and To not set")
+	}
+	if iq.Type != "set" {
+		return nil
+	}
+	if !service.isAdmin(*iq.From) {
+		return service.sendXMPPIq(makeIqError(iq, "auth", "forbidden"))
+	}
+	command, commandExists := findAdminCommand(iq.Command.Node)
+	if !commandExists || iq.To.LocalPart != "" {
+		return service.sendXMPPIq(makeIqError(iq, "cancel", "item-not-found"))
+	}
+
+	response := &adHocCommand{
+		Node:      command.node,
+		SessionID: iq.Command.SessionID,
+	}
+	if response.SessionID == "" {
+		response.SessionID = xmpp.RandomID()
+	}
+
+	switch {
+	case iq.Command.Action == "cancel":
+		response.Status = "canceled"
+	case command.form != nil && (iq.Command.Form == nil || iq.Command.Form.Type != "submit"):
+		response.Status = "executing"
+		response.Form = command.form(service)
+	default:
+		values := make(formValues)
+		if iq.Command.Form != nil {
+			for _, field := range iq.Command.Form.Fields {
+				values[field.Var] = field.Values
+			}
+		}
+		response.Status = "completed"
+		if note, err := command.execute(service, values); err != nil {
+			response.Notes = []commandNote{{Type: "error", Text: err.Error()}}
+		} else {
+			response.Notes = []commandNote{{Type: "info", Text: note}}
+		}
+	}
+
+	reply := makeIqReply(iq, "result")
+	reply.Command = response
+	return service.sendXMPPIq(reply)
+}
+
+func (service *Service) providerNames() []string {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	names := make([]string, 0, len(service.providers))
+	for name := range service.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (service *Service) userAddresses() []xmpp.Address {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	addresses := make([]xmpp.Address, 0, len(service.users))
+	for address := range service.users {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].String() < addresses[j].String() })
+	return addresses
+}
+
+func (service *Service) userJIDs() []string {
+	addresses := service.userAddresses()
+	jids := make([]string, len(addresses))
+	for i, address := range addresses {
+		jids[i] = address.String()
+	}
+	return jids
+}
+
+func makeOptions(values []string) []dataFormOption {
+	options := make([]dataFormOption, len(values))
+	for i, value := range values {
+		options[i] = dataFormOption{Value: value}
+	}
+	return options
+}
+
+func (service *Service) addUserForm() *dataForm {
+	return &dataForm{
+		Type:         "form",
+		Title:        "Add User",
+		Instructions: "The user will be removed when sms-over-xmpp restarts or reloads its configuration unless you also add them to the users file.",
+		Fields: []dataFormField{
+			{Var: "jid", Type: "jid-single", Label: "Bare JID", Required: true},
+			{Var: "provider", Type: "list-single", Label: "Provider", Required: true, Options: makeOptions(service.providerNames())},
+			{Var: "phone_number", Type: "text-single", Label: "Phone number (e.g. +12125551212)", Required: true},
+		},
+	}
+}
+
+func (service *Service) executeAddUser(values formValues) (string, error) {
+	address, err := xmpp.ParseAddress(values.get("jid"))
+	if err != nil {
+		return "", fmt.Errorf("Malformed JID: %s", err)
+	}
+	phoneNumber := values.get("phone_number")
+	if err := validateE164(phoneNumber); err != nil {
+		return "", fmt.Errorf("Invalid phone number '%s': %s", phoneNumber, err)
+	}
+	if err := service.addUser(*address.Bare(), values.get("provider"), phoneNumber); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Added %s with phone number %s", address.Bare(), phoneNumber), nil
+}
+
+func (service *Service) removeUserForm() *dataForm {
+	return &dataForm{
+		Type:         "form",
+		Title:        "Remove User",
+		Instructions: "The user will come back when sms-over-xmpp restarts or reloads its configuration unless you also remove them from the users file.",
+		Fields: []dataFormField{
+			{Var: "jid", Type: "list-single", Label: "User", Required: true, Options: makeOptions(service.userJIDs())},
+		},
+	}
+}
+
+func (service *Service) executeRemoveUser(values formValues) (string, error) {
+	address, err := xmpp.ParseAddress(values.get("jid"))
+	if err != nil {
+		return "", fmt.Errorf("Malformed JID: %s", err)
+	}
+	if err := service.removeUser(*address.Bare()); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Removed %s", address.Bare()), nil
+}
+
+func (service *Service) executeReload(values formValues) (string, error) {
+	if err := service.Reload(); err != nil {
+		return "", fmt.Errorf("Reloading configuration failed (the existing configuration is still in effect): %s", err)
+	}
+	return "Reloaded configuration with providers: " + strings.Join(service.providerNames(), ", "), nil
+}
+
+func (service *Service) executeProviderStats(values formValues) (string, error) {
+	var lines []string
+	for _, name := range service.providerNames() {
+		stats := service.getProviderStats(name)
+		lines = append(lines, fmt.Sprintf("%s: %d sent, %d failed", name, stats.sent, stats.failed))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (service *Service) broadcastForm() *dataForm {
+	return &dataForm{
+		Type:  "form",
+		Title: "Broadcast Notice to All Users",
+		Fields: []dataFormField{
+			{Var: "message", Type: "text-multi", Label: "Message", Required: true},
+		},
+	}
+}
+
+func (service *Service) executeBroadcast(values formValues) (string, error) {
+	message := strings.Join(values["message"], "\n")
+	if message == "" {
+		return "", errors.New("The message is empty")
+	}
+	from := xmpp.Address{DomainPart: service.xmppParams.Domain}
+	addresses := service.userAddresses()
+	var failures []string
+	for _, to := range addresses {
+		if err := service.sendXMPPChat(from, to, message); err != nil {
+			failures = append(failures, fmt.Sprintf("%s (%s)", to, err))
+		}
+	}
+	if len(failures) > 0 {
+		return "", fmt.Errorf("Sent notice to %d of %d users; sending to the following users failed: %s", len(addresses)-len(failures), len(addresses), strings.Join(failures, ", "))
+	}
+	return fmt.Sprintf("Sent notice to %d users", len(addresses)), nil
+}
@@ -0,0 +1,257 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// The connection to the XMPP server, using the Jabber Component Protocol
+// (XEP-0114)
+
+const (
+	streamsNS          = "http://etherx.jabber.org/streams"
+	componentKeepalive = 60 * time.Second
+)
+
+type componentParams struct {
+	Domain string
+	Secret string
+	Server string // host:port of the server's component listener
+	Logger *log.Logger
+}
+
+type componentCallbacks struct {
+	Message  func(context.Context, *messageStanza) error
+	Presence func(context.Context, *presenceStanza) error
+	Iq       func(context.Context, *iqStanza) error
+}
+
+// runComponent connects to the XMPP server, authenticates as params.Domain,
+// and then invokes callbacks for each incoming stanza and sends each stanza
+// received from sendChan, until the connection fails or ctx is canceled.
+// Callbacks are invoked sequentially in the order the stanzas are received,
+// and errors returned by them are logged.
+func runComponent(ctx context.Context, params componentParams, callbacks componentCallbacks, sendChan <-chan interface{}) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", params.Server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	decoder := xml.NewDecoder(conn)
+	if err := openComponentStream(conn, decoder, params); err != nil {
+		return fmt.Errorf("XMPP component handshake failed: %w", err)
+	}
+
+	sendErr := make(chan error, 1)
+	sendCtx, cancelSend := context.WithCancel(ctx)
+	defer cancelSend()
+	go func() {
+		sendErr <- sendStanzas(sendCtx, conn, sendChan)
+		conn.Close()
+	}()
+
+	receiveErr := receiveStanzas(ctx, decoder, params, callbacks)
+	cancelSend()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := <-sendErr; err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return receiveErr
+}
+
+// openComponentStream opens the XML stream and performs the handshake
+func openComponentStream(conn net.Conn, decoder *xml.Decoder, params componentParams) error {
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := io.WriteString(conn, `<?xml version="1.0"?><stream:stream xmlns="`+componentNS+`" xmlns:stream="`+streamsNS+`" to="`+escapeXMLAttr(params.Domain)+`">`); err != nil {
+		return err
+	}
+	streamStart, err := nextStartElement(decoder)
+	if err != nil {
+		return err
+	}
+	if streamStart.Name.Space != streamsNS || streamStart.Name.Local != "stream" {
+		return fmt.Errorf("server sent <%s> instead of a stream header", streamStart.Name.Local)
+	}
+	var streamID string
+	for _, attr := range streamStart.Attr {
+		if attr.Name.Space == "" && attr.Name.Local == "id" {
+			streamID = attr.Value
+		}
+	}
+	if streamID == "" {
+		return errors.New("server did not send a stream ID")
+	}
+
+	digest := sha1.Sum([]byte(streamID + params.Secret))
+	if _, err := io.WriteString(conn, "<handshake>"+hex.EncodeToString(digest[:])+"</handshake>"); err != nil {
+		return err
+	}
+	response, err := nextStartElement(decoder)
+	if err != nil {
+		return err
+	}
+	if response.Name.Space == streamsNS && response.Name.Local == "error" {
+		return decodeStreamError(decoder, response)
+	}
+	if response.Name.Local != "handshake" {
+		return fmt.Errorf("server sent <%s> instead of a handshake response", response.Name.Local)
+	}
+	return decoder.Skip()
+}
+
+func receiveStanzas(ctx context.Context, decoder *xml.Decoder, params componentParams, callbacks componentCallbacks) error {
+	for {
+		start, err := nextStartElement(decoder)
+		if err == io.EOF {
+			return errors.New("XMPP server closed the stream")
+		} else if err != nil {
+			return err
+		}
+		if start.Name.Space == streamsNS && start.Name.Local == "error" {
+			return decodeStreamError(decoder, start)
+		}
+		if start.Name.Space != componentNS {
+			if err := decoder.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch start.Name.Local {
+		case "message":
+			stanza := new(messageStanza)
+			if err := decoder.DecodeElement(stanza, &start); err != nil {
+				return err
+			}
+			err = callbacks.Message(ctx, stanza)
+		case "presence":
+			stanza := new(presenceStanza)
+			if err := decoder.DecodeElement(stanza, &start); err != nil {
+				return err
+			}
+			err = callbacks.Presence(ctx, stanza)
+		case "iq":
+			stanza := new(iqStanza)
+			if err := decoder.DecodeElement(stanza, &start); err != nil {
+				return err
+			}
+			err = callbacks.Iq(ctx, stanza)
+		default:
+			err = decoder.Skip()
+		}
+		if err != nil {
+			params.Logger.Printf("Error handling XMPP %s stanza: %s", start.Name.Local, err)
+		}
+	}
+}
+
+func sendStanzas(ctx context.Context, conn net.Conn, sendChan <-chan interface{}) error {
+	encoder := xml.NewEncoder(conn)
+	keepalive := time.NewTicker(componentKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case stanza := <-sendChan:
+			if err := encoder.Encode(stanza); err != nil {
+				return err
+			}
+		case <-keepalive.C:
+			if _, err := io.WriteString(conn, " "); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			io.WriteString(conn, "</stream:stream>")
+			return ctx.Err()
+		}
+	}
+}
+
+// nextStartElement returns the next start element at the current depth,
+// or io.EOF if the enclosing element (i.e. the stream) ends
+func nextStartElement(decoder *xml.Decoder) (xml.StartElement, error) {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			return token, nil
+		case xml.EndElement:
+			return xml.StartElement{}, io.EOF
+		}
+	}
+}
+
+func decodeStreamError(decoder *xml.Decoder, start xml.StartElement) error {
+	var streamError struct {
+		Conditions []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+		Text string `xml:"urn:ietf:params:xml:ns:xmpp-streams text"`
+	}
+	if err := decoder.DecodeElement(&streamError, &start); err != nil {
+		return err
+	}
+	condition := "unknown error"
+	for _, c := range streamError.Conditions {
+		if c.XMLName.Local != "text" {
+			condition = c.XMLName.Local
+			break
+		}
+	}
+	if streamError.Text != "" {
+		return fmt.Errorf("XMPP stream error: %s (%s)", condition, streamError.Text)
+	}
+	return fmt.Errorf("XMPP stream error: %s", condition)
+}
+
+func escapeXMLAttr(value string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(value))
+	return escaped.String()
+}
@@ -28,8 +28,9 @@
 package config
 
 type UserConfig struct {
-	PhoneNumber string // e.g. "+19255551212"
-	Provider    string
+	PhoneNumber      string // e.g. "+19255551212"
+	Provider         string
+	ReplyQuoteLength int // number of characters of the original message to quote in replies; 0 to omit the quote
 }
 
 type ProviderConfig struct {
@@ -37,13 +38,36 @@ type ProviderConfig struct {
 	Params map[string]string
 }
 
+const (
+	DefaultRosterInterval   = 15
+	DefaultReplyQuoteLength = 40
+)
+
 type Config struct {
-	XMPPServer    string // e.g. "xmpp.example.com:5347"
-	XMPPDomain    string // e.g. "sms.example.com"
-	XMPPSecret    string
-	DefaultPrefix string // e.g. "+1"; prepended to phone numbers that don't start with +
-	PublicURL     string
-	Users         map[string]UserConfig // Map from bare JID -> UserConfig
-	Providers     map[string]ProviderConfig
-	Rosters       map[string]string // Map from bare JID -> CardDAV URL
+	XMPPServer         string // e.g. "xmpp.example.com:5347"
+	XMPPDomain         string // e.g. "sms.example.com"
+	XMPPSecret         string
+	DefaultPrefix      string // e.g. "+1"; prepended to phone numbers that don't start with +
+	PublicURL          string
+	AdminHTTPPassword  string                // enables the /admin/reload HTTP endpoint if non-empty
+	MediaUploadService string                // e.g. "upload.example.com"; XEP-0363 service for re-hosting inbound media
+	MediaDir           string                // directory for re-hosted inbound media and converted outbound media, served under PublicURL + "/media/"
+	MediaRetention     int                   // days to keep re-hosted inbound media in MediaDir; 0 to keep it forever
+	StateDir           string                // directory in which to save state, such as address book synchronization state, across restarts
+	CombineMMS         bool                  // deliver inbound MMS as a single XMPP message with text and attachments
+	Transliterate      bool                  // replace characters outside GSM-7 when that avoids UCS-2 encoding
+	SegmentNotice      int                   // notify users when a message is longer than this many SMS segments; 0 to disable
+	SendDelay          int                   // seconds to hold outbound messages so they can be corrected or retracted
+	RosterDefaultGroup string                // roster group for address book contacts without categories; "" for none
+	RosterBookGroup    bool                  // put address book contacts in a roster group named after the address book
+	RosterAllNumbers   bool                  // add every SMS-capable number of an address book contact to the roster, not just the first
+	RosterTwoWay       bool                  // write contacts added or changed in the roster back to the address book
+	RosterInterval     int                   // seconds between address book synchronizations
+	RosterIntervals    map[string]int        // Map from bare JID -> seconds between synchronizations, overriding RosterInterval
+	RosterPrefixes     map[string]string     // Map from bare JID -> prefix for national numbers in the user's address book, overriding DefaultPrefix
+	Users              map[string]UserConfig // Map from bare JID -> UserConfig
+	Providers          map[string]ProviderConfig
+	Rosters            map[string]string // Map from bare JID -> contact source URL
+	SharedRoster       string            // contact source URL merged into every user's roster; "" for none
+	Admins             []string          // Bare JIDs allowed to run ad-hoc commands
 }
@@ -32,11 +32,17 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
+// loadConfigFile reads a file of lines containing a name, whitespace, and a
+// value.  As before this file format was validated, blank lines, comments,
+// and lines which don't contain exactly two fields are ignored, and the last
+// of several lines with the same name wins.
 func loadConfigFile(filename string) (map[string]string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -45,39 +51,124 @@ func loadConfigFile(filename string) (map[string]string, error) {
 	defer file.Close()
 	scanner := bufio.NewScanner(file)
 	config := make(map[string]string)
-	for scanner.Scan() {
+	var errs []error
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
 		line := scanner.Text()
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
 		fields := strings.Fields(line)
-		if len(fields) == 2 {
-			config[fields[0]] = fields[1]
+		if len(fields) != 2 {
+			continue
+		}
+		if _, exists := config[fields[0]]; exists {
+			log.Printf("Warning: %s:%d: %s is specified more than once; using the last value", filename, lineNumber, fields[0])
+		}
+		value, err := expandReferences(fields[1], filepath.Dir(filename))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %s: %s", filename, lineNumber, fields[0], err))
+			continue
 		}
+		config[fields[0]] = value
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, fmt.Errorf("Error reading %s: %s", filename, err)
 	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
 	return config, nil
 }
 
+func loadListFile(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	var list []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("%s contains malformed line %q (should contain exactly one item)", filename, line)
+		}
+		item, err := expandReferences(fields[0], filepath.Dir(filename))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		list = append(list, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading %s: %s", filename, err)
+	}
+	return list, nil
+}
+
 func loadUsersFile(filename string) (map[string]UserConfig, error) {
 	params, err := loadConfigFile(filename)
 	if err != nil {
 		return nil, err
 	}
 	users := make(map[string]UserConfig)
+	var errs []error
 	for userJID, userSpec := range params {
 		fields := strings.SplitN(userSpec, ":", 2)
 		if len(fields) != 2 {
-			return nil, fmt.Errorf("User %s in %s has malformed configuration (should look like provider:phonenumber)", userJID, filename)
+			errs = append(errs, fmt.Errorf("User %s in %s has malformed configuration (should look like provider:phonenumber)", userJID, filename))
+			continue
 		}
 		users[userJID] = UserConfig{
-			Provider:    fields[0],
-			PhoneNumber: fields[1],
+			Provider:         fields[0],
+			PhoneNumber:      fields[1],
+			ReplyQuoteLength: DefaultReplyQuoteLength,
+		}
+	}
+	return users, errors.Join(errs...)
+}
+
+func loadReplyQuotesFile(filename string, users map[string]UserConfig) error {
+	params, err := loadConfigFile(filename)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for userJID, value := range params {
+		user, exists := users[userJID]
+		if !exists {
+			errs = append(errs, fmt.Errorf("%s refers to user %s, who is not in the users file", filename, userJID))
+			continue
 		}
+		user.ReplyQuoteLength, err = strconv.Atoi(value)
+		if err != nil || user.ReplyQuoteLength < 0 {
+			errs = append(errs, fmt.Errorf("%s: quote length for user %s must be a non-negative integer", filename, userJID))
+			continue
+		}
+		users[userJID] = user
 	}
-	return users, nil
+	return errors.Join(errs...)
+}
+
+func loadRosterIntervalsFile(filename string) (map[string]int, error) {
+	params, err := loadConfigFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	intervals := make(map[string]int)
+	var errs []error
+	for userJID, value := range params {
+		interval, err := strconv.Atoi(value)
+		if err != nil || interval <= 0 {
+			errs = append(errs, fmt.Errorf("%s: interval for user %s must be a positive integer", filename, userJID))
+			continue
+		}
+		intervals[userJID] = interval
+	}
+	return intervals, errors.Join(errs...)
 }
 
 func loadProviderConfigFile(filename string) (ProviderConfig, error) {
@@ -103,6 +194,7 @@ func loadProvidersDirectory(dirpath string) (map[string]ProviderConfig, error) {
 		return nil, err
 	}
 	providers := make(map[string]ProviderConfig)
+	var errs []error
 	for _, providerFile := range providerFiles {
 		providerFileName := providerFile.Name()
 		if strings.HasPrefix(providerFileName, ".") {
@@ -110,35 +202,132 @@ func loadProvidersDirectory(dirpath string) (map[string]ProviderConfig, error) {
 		}
 		providerConfig, err := loadProviderConfigFile(filepath.Join(dirpath, providerFileName))
 		if err != nil {
-			return nil, err
+			errs = append(errs, err)
+			continue
 		}
 		providers[providerFileName] = providerConfig
 	}
-	return providers, nil
+	return providers, errors.Join(errs...)
 }
 
+var knownConfigParams = map[string]bool{
+	"xmpp_server":          true,
+	"xmpp_domain":          true,
+	"xmpp_secret":          true,
+	"default_prefix":       true,
+	"public_url":           true,
+	"admin_http_password":  true,
+	"media_upload_service": true,
+	"media_dir":            true,
+	"media_retention":      true,
+	"state_dir":            true,
+	"combine_mms":          true,
+	"transliterate":        true,
+	"segment_notice":       true,
+	"send_delay":           true,
+	"roster_default_group": true,
+	"roster_book_group":    true,
+	"roster_all_numbers":   true,
+	"roster_two_way":       true,
+	"roster_interval":      true,
+}
+
+// FromDirectory loads the configuration from a configuration directory.
+// Every problem found is reported, not just the first.
 func FromDirectory(dirpath string) (*Config, error) {
 	config := new(Config)
+	var errs []error
 
-	params, err := loadConfigFile(filepath.Join(dirpath, "config"))
+	configFilename := filepath.Join(dirpath, "config")
+	params, err := loadConfigFile(configFilename)
 	if err != nil {
-		return nil, err
+		errs = append(errs, err)
+	}
+	for name := range params {
+		if !knownConfigParams[name] {
+			log.Printf("Warning: %s: ignoring unknown parameter %s", configFilename, name)
+		}
+	}
+	parseBool := func(name string, value *bool) {
+		if param, exists := params[name]; exists {
+			var err error
+			if *value, err = strconv.ParseBool(param); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s must be true or false", configFilename, name))
+			}
+		}
 	}
 	config.XMPPServer = params["xmpp_server"]
 	config.XMPPDomain = params["xmpp_domain"]
 	config.XMPPSecret = params["xmpp_secret"]
+	config.DefaultPrefix = params["default_prefix"]
 	config.PublicURL = params["public_url"]
+	config.AdminHTTPPassword = params["admin_http_password"]
+	config.MediaUploadService = params["media_upload_service"]
+	config.MediaDir = params["media_dir"]
+	config.StateDir = params["state_dir"]
+	if value, exists := params["media_retention"]; exists {
+		config.MediaRetention, err = strconv.Atoi(value)
+		if err != nil || config.MediaRetention < 0 {
+			errs = append(errs, fmt.Errorf("%s: media_retention must be a non-negative integer", configFilename))
+		}
+	}
+	parseBool("combine_mms", &config.CombineMMS)
+	parseBool("transliterate", &config.Transliterate)
+	if value, exists := params["segment_notice"]; exists {
+		config.SegmentNotice, err = strconv.Atoi(value)
+		if err != nil || config.SegmentNotice < 0 {
+			errs = append(errs, fmt.Errorf("%s: segment_notice must be a non-negative integer", configFilename))
+		}
+	}
+	if value, exists := params["send_delay"]; exists {
+		config.SendDelay, err = strconv.Atoi(value)
+		if err != nil || config.SendDelay < 0 {
+			errs = append(errs, fmt.Errorf("%s: send_delay must be a non-negative integer", configFilename))
+		}
+	}
+	config.RosterDefaultGroup = params["roster_default_group"]
+	parseBool("roster_book_group", &config.RosterBookGroup)
+	parseBool("roster_all_numbers", &config.RosterAllNumbers)
+	parseBool("roster_two_way", &config.RosterTwoWay)
+	config.RosterInterval = DefaultRosterInterval
+	if value, exists := params["roster_interval"]; exists {
+		config.RosterInterval, err = strconv.Atoi(value)
+		if err != nil || config.RosterInterval <= 0 {
+			errs = append(errs, fmt.Errorf("%s: roster_interval must be a positive integer", configFilename))
+		}
+	}
 	config.Users, err = loadUsersFile(filepath.Join(dirpath, "users"))
 	if err != nil {
-		return nil, err
+		errs = append(errs, err)
+	} else if err := loadReplyQuotesFile(filepath.Join(dirpath, "reply_quotes"), config.Users); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		errs = append(errs, err)
 	}
 	config.Providers, err = loadProvidersDirectory(filepath.Join(dirpath, "providers"))
 	if err != nil {
-		return nil, err
+		errs = append(errs, err)
+	}
+	config.RosterIntervals, err = loadRosterIntervalsFile(filepath.Join(dirpath, "roster_intervals"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		errs = append(errs, err)
+	}
+	config.RosterPrefixes, err = loadConfigFile(filepath.Join(dirpath, "roster_prefixes"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		errs = append(errs, err)
 	}
 	config.Rosters, err = loadConfigFile(filepath.Join(dirpath, "rosters"))
 	if err != nil && !errors.Is(err, fs.ErrNotExist) {
-		return nil, err
+		errs = append(errs, err)
+	}
+	if sharedRoster, ok := config.Rosters["*"]; ok {
+		config.SharedRoster = sharedRoster
+		delete(config.Rosters, "*")
+	}
+	config.Admins, err = loadListFile(filepath.Join(dirpath, "admins"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
 	return config, nil
 }
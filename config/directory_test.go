@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "secret"), []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SMS_OVER_XMPP_TEST", "from-env")
+
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:  "simple",
+			input: "xmpp_server localhost:5347\nxmpp_domain sms.example.com\n",
+			want:  map[string]string{"xmpp_server": "localhost:5347", "xmpp_domain": "sms.example.com"},
+		},
+		{
+			name:  "comments and blank lines",
+			input: "# comment\n\nxmpp_domain sms.example.com\n",
+			want:  map[string]string{"xmpp_domain": "sms.example.com"},
+		},
+		{
+			name:  "extra whitespace",
+			input: "xmpp_domain \t  sms.example.com  \n",
+			want:  map[string]string{"xmpp_domain": "sms.example.com"},
+		},
+		{
+			name:  "lines without two fields are ignored",
+			input: "xmpp_domain\nxmpp_server localhost 5347\nxmpp_secret s\n",
+			want:  map[string]string{"xmpp_secret": "s"},
+		},
+		{
+			name:  "relative file reference",
+			input: "xmpp_secret ${file:secret}\n",
+			want:  map[string]string{"xmpp_secret": "hunter2"},
+		},
+		{
+			name:  "environment reference",
+			input: "xmpp_secret prefix-${env:SMS_OVER_XMPP_TEST}\n",
+			want:  map[string]string{"xmpp_secret": "prefix-from-env"},
+		},
+		{
+			name:  "duplicate",
+			input: "xmpp_domain a.example.com\nxmpp_domain b.example.com\n",
+			want:  map[string]string{"xmpp_domain": "b.example.com"},
+		},
+		{
+			name:    "unresolvable reference",
+			input:   "xmpp_secret ${file:nonexistent}\n",
+			wantErr: "config:1: xmpp_secret:",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(dir, "config")
+			if err := os.WriteFile(filename, []byte(test.input), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadConfigFile(filename)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !maps.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFromDirectoryReportsAllErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, contents string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("config", "xmpp_domain sms.example.com\nbogus_param 1\ncombine_mms maybe\n")
+	writeFile("users", "user@example.com twilio\n")
+	writeFile("providers/twilio", "account_sid x\n")
+
+	_, err := FromDirectory(dir)
+	if err == nil {
+		t.Fatal("FromDirectory succeeded with an invalid configuration")
+	}
+	for _, want := range []string{
+		"combine_mms must be true or false",
+		"User user@example.com in " + filepath.Join(dir, "users") + " has malformed configuration",
+		"lacks type parameter",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+type fileError struct {
+	line    int
+	message string
+}
+
+type fileParser struct {
+	filename string
+	errs     []fileError
+}
+
+func (parser *fileParser) errorf(node *yaml.Node, format string, args ...interface{}) {
+	parser.errs = append(parser.errs, fileError{line: node.Line, message: fmt.Sprintf(format, args...)})
+}
+
+func (parser *fileParser) err() error {
+	sort.SliceStable(parser.errs, func(i, j int) bool { return parser.errs[i].line < parser.errs[j].line })
+	errs := make([]error, len(parser.errs))
+	for i, fileErr := range parser.errs {
+		errs[i] = fmt.Errorf("%s:%d: %s", parser.filename, fileErr.line, fileErr.message)
+	}
+	return errors.Join(errs...)
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+func (parser *fileParser) scalar(node *yaml.Node, what string) string {
+	node = resolveAlias(node)
+	if node.Kind != yaml.ScalarNode {
+		parser.errorf(node, "%s must be a string", what)
+		return ""
+	}
+	value, err := expandReferences(node.Value, filepath.Dir(parser.filename))
+	if err != nil {
+		parser.errorf(node, "%s: %s", what, err)
+		return ""
+	}
+	return value
+}
+
+func (parser *fileParser) boolean(node *yaml.Node, what string) bool {
+	value := parser.scalar(node, what)
+	if value == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		parser.errorf(node, "%s must be true or false", what)
+	}
+	return b
+}
+
+func (parser *fileParser) integer(node *yaml.Node, what string) int {
+	value := parser.scalar(node, what)
+	if value == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		parser.errorf(node, "%s must be a non-negative integer", what)
+	}
+	return i
+}
+
+func (parser *fileParser) list(node *yaml.Node, what string) []string {
+	node = resolveAlias(node)
+	if node.Kind != yaml.SequenceNode {
+		parser.errorf(node, "%s must be a list", what)
+		return nil
+	}
+	var list []string
+	for _, item := range node.Content {
+		list = append(list, parser.scalar(item, "item in "+what))
+	}
+	return list
+}
+
+func (parser *fileParser) forEach(node *yaml.Node, what string, f func(key string, keyNode *yaml.Node, valueNode *yaml.Node)) {
+	node = resolveAlias(node)
+	if node.Kind != yaml.MappingNode {
+		parser.errorf(node, "%s must be a mapping", what)
+		return
+	}
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		if keyNode.Kind != yaml.ScalarNode {
+			parser.errorf(keyNode, "key in %s must be a string", what)
+			continue
+		}
+		if seen[keyNode.Value] {
+			parser.errorf(keyNode, "key %q is specified more than once in %s", keyNode.Value, what)
+			continue
+		}
+		seen[keyNode.Value] = true
+		f(keyNode.Value, keyNode, valueNode)
+	}
+}
+
+func (parser *fileParser) parseConfig(node *yaml.Node) *Config {
+	config := &Config{
+		RosterInterval:  DefaultRosterInterval,
+		RosterIntervals: make(map[string]int),
+		RosterPrefixes:  make(map[string]string),
+		Users:           make(map[string]UserConfig),
+		Providers:       make(map[string]ProviderConfig),
+		Rosters:         make(map[string]string),
+	}
+	seen := make(map[string]bool)
+	userNodes := make(map[string]*yaml.Node)
+	parser.forEach(node, "configuration", func(key string, keyNode *yaml.Node, valueNode *yaml.Node) {
+		seen[key] = true
+		switch key {
+		case "xmpp_server":
+			config.XMPPServer = parser.scalar(valueNode, key)
+		case "xmpp_domain":
+			config.XMPPDomain = parser.scalar(valueNode, key)
+		case "xmpp_secret":
+			config.XMPPSecret = parser.scalar(valueNode, key)
+		case "default_prefix":
+			config.DefaultPrefix = parser.scalar(valueNode, key)
+		case "public_url":
+			config.PublicURL = parser.scalar(valueNode, key)
+		case "admin_http_password":
+			config.AdminHTTPPassword = parser.scalar(valueNode, key)
+		case "media_upload_service":
+			config.MediaUploadService = parser.scalar(valueNode, key)
+		case "media_dir":
+			config.MediaDir = parser.scalar(valueNode, key)
+		case "media_retention":
+			config.MediaRetention = parser.integer(valueNode, key)
+		case "state_dir":
+			config.StateDir = parser.scalar(valueNode, key)
+		case "combine_mms":
+			config.CombineMMS = parser.boolean(valueNode, key)
+		case "transliterate":
+			config.Transliterate = parser.boolean(valueNode, key)
+		case "send_delay":
+			config.SendDelay = parser.integer(valueNode, key)
+		case "segment_notice":
+			config.SegmentNotice = parser.integer(valueNode, key)
+		case "roster_default_group":
+			config.RosterDefaultGroup = parser.scalar(valueNode, key)
+		case "roster_book_group":
+			config.RosterBookGroup = parser.boolean(valueNode, key)
+		case "roster_all_numbers":
+			config.RosterAllNumbers = parser.boolean(valueNode, key)
+		case "roster_two_way":
+			config.RosterTwoWay = parser.boolean(valueNode, key)
+		case "roster_interval":
+			config.RosterInterval = parser.integer(valueNode, key)
+		case "shared_roster":
+			config.SharedRoster = parser.scalar(valueNode, key)
+		case "admins":
+			config.Admins = parser.list(valueNode, key)
+		case "providers":
+			parser.forEach(valueNode, key, func(name string, nameNode *yaml.Node, providerNode *yaml.Node) {
+				config.Providers[name] = parser.parseProvider(name, nameNode, providerNode)
+			})
+		case "users":
+			parser.forEach(valueNode, key, func(jid string, jidNode *yaml.Node, userNode *yaml.Node) {
+				parser.parseUser(config, jid, jidNode, userNode)
+				userNodes[jid] = jidNode
+			})
+		default:
+			parser.errorf(keyNode, "unknown key %q", key)
+		}
+	})
+	for _, required := range []string{"xmpp_server", "xmpp_domain", "xmpp_secret"} {
+		if !seen[required] {
+			parser.errorf(node, "required key %q is missing", required)
+		}
+	}
+	for jid, user := range config.Users {
+		if _, exists := config.Providers[user.Provider]; user.Provider != "" && !exists {
+			parser.errorf(userNodes[jid], "user %s refers to non-existent provider %s", jid, user.Provider)
+		}
+	}
+	return config
+}
+
+func (parser *fileParser) parseProvider(name string, nameNode *yaml.Node, node *yaml.Node) ProviderConfig {
+	provider := ProviderConfig{Params: make(map[string]string)}
+	parser.forEach(node, "provider "+name, func(key string, keyNode *yaml.Node, valueNode *yaml.Node) {
+		value := parser.scalar(valueNode, key)
+		if key == "type" {
+			provider.Type = value
+		} else {
+			provider.Params[key] = value
+		}
+	})
+	if provider.Type == "" {
+		parser.errorf(nameNode, "provider %s lacks type parameter", name)
+	}
+	return provider
+}
+
+func (parser *fileParser) parseUser(config *Config, jid string, jidNode *yaml.Node, node *yaml.Node) {
+	user := UserConfig{ReplyQuoteLength: DefaultReplyQuoteLength}
+	parser.forEach(node, "user "+jid, func(key string, keyNode *yaml.Node, valueNode *yaml.Node) {
+		switch key {
+		case "provider":
+			user.Provider = parser.scalar(valueNode, key)
+		case "phone_number":
+			user.PhoneNumber = parser.scalar(valueNode, key)
+		case "roster":
+			config.Rosters[jid] = parser.scalar(valueNode, key)
+		case "roster_interval":
+			config.RosterIntervals[jid] = parser.integer(valueNode, key)
+		case "roster_prefix":
+			config.RosterPrefixes[jid] = parser.scalar(valueNode, key)
+		case "reply_quote_length":
+			user.ReplyQuoteLength = parser.integer(valueNode, key)
+		default:
+			parser.errorf(keyNode, "unknown key %q for user %s", key, jid)
+		}
+	})
+	if user.Provider == "" {
+		parser.errorf(jidNode, "user %s lacks provider", jid)
+	}
+	if user.PhoneNumber == "" {
+		parser.errorf(jidNode, "user %s lacks phone_number", jid)
+	}
+	config.Users[jid] = user
+}
+
+// FromFile loads the configuration from a single YAML file.  Unknown keys
+// and malformed entries are reported together, each with its line number.
+func FromFile(filename string) (*Config, error) {
+	fileBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(fileBytes, &document); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 {
+		return nil, fmt.Errorf("%s: file is empty", filename)
+	}
+
+	parser := &fileParser{filename: filename}
+	config := parser.parseConfig(document.Content[0])
+	if err := parser.err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Load loads the configuration from path, which is either a configuration
+// directory or a single YAML file.
+func Load(path string) (*Config, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return FromDirectory(path)
+	}
+	return FromFile(path)
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// expandReferences replaces every ${env:NAME}, ${file:PATH}, and
+// ${credential:NAME} in value with the contents of the environment variable,
+// file, or systemd credential, respectively.  This allows secrets to be kept
+// out of the configuration.  $${ produces a literal ${.  A relative PATH is
+// resolved against dir, the directory containing the configuration file in
+// which the reference appears.
+func expandReferences(value string, dir string) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+	var result strings.Builder
+	for {
+		i := strings.IndexByte(value, '$')
+		if i == -1 {
+			result.WriteString(value)
+			return result.String(), nil
+		}
+		result.WriteString(value[:i])
+		value = value[i:]
+
+		switch {
+		case strings.HasPrefix(value, "$${"):
+			result.WriteString("${")
+			value = value[3:]
+		case strings.HasPrefix(value, "${"):
+			end := strings.IndexByte(value, '}')
+			if end == -1 {
+				return "", errors.New("${ is not terminated by }")
+			}
+			resolved, err := resolveReference(value[2:end], dir)
+			if err != nil {
+				return "", err
+			}
+			result.WriteString(resolved)
+			value = value[end+1:]
+		default:
+			result.WriteByte('$')
+			value = value[1:]
+		}
+	}
+}
+
+func resolveReference(reference string, dir string) (string, error) {
+	kind, name, _ := strings.Cut(reference, ":")
+	if name == "" {
+		return "", fmt.Errorf("${%s} is malformed (should look like ${env:NAME}, ${file:PATH}, or ${credential:NAME})", reference)
+	}
+	switch kind {
+	case "env":
+		value, exists := os.LookupEnv(name)
+		if !exists {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	case "file":
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		return readSecretFile(name)
+	case "credential":
+		credentialsDirectory := os.Getenv("CREDENTIALS_DIRECTORY")
+		if credentialsDirectory == "" {
+			return "", fmt.Errorf("cannot load credential %s because $CREDENTIALS_DIRECTORY is not set (are you running under systemd with LoadCredential=?)", name)
+		}
+		if strings.ContainsRune(name, '/') {
+			return "", fmt.Errorf("credential name %q must not contain /", name)
+		}
+		return readSecretFile(filepath.Join(credentialsDirectory, name))
+	default:
+		return "", fmt.Errorf("${%s} has unknown reference type %q (should be env, file, or credential)", reference, kind)
+	}
+}
+
+func readSecretFile(filename string) (string, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(contents), "\r\n"), nil
+}
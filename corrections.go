@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	correctionNS = "urn:xmpp:message-correct:0"
+	retractionNS = "urn:xmpp:message-retract:1"
+)
+
+// An SMS can't be changed once it has been sent, so a correction (XEP-0308)
+// or retraction (XEP-0424) can only be fully honored while the original
+// message is still in the outbox, which holds messages until they are passed
+// to the provider (for send_delay seconds, and while outbound media is being
+// processed).  After that, corrections are sent as a follow-up SMS and
+// retractions are refused.
+
+const correctionPrefix = "Correction: "
+
+type outboxKey struct {
+	conversation
+	id string // ID of the XMPP message
+}
+
+func (service *Service) makeOutboxKey(xmppMessage *messageStanza) (outboxKey, bool) {
+	conv, ok := service.makeConversation(*xmppMessage.From, *xmppMessage.To)
+	return outboxKey{conversation: conv, id: xmppMessage.ID}, ok
+}
+
+type outboxEntry struct {
+	message    *Message
+	correction bool // message is a follow-up correction of a message which has already been sent
+}
+
+func (service *Service) queueOutbound(key outboxKey, message *Message, correction bool) {
+	if key.id == "" {
+		return
+	}
+	service.outboxMu.Lock()
+	defer service.outboxMu.Unlock()
+	service.outbox[key] = outboxEntry{message: message, correction: correction}
+}
+
+// dequeueOutbound removes the message from the outbox, returning false if it
+// was retracted and should not be sent
+func (service *Service) dequeueOutbound(key outboxKey, message *Message) bool {
+	if key.id == "" {
+		return true
+	}
+	service.outboxMu.Lock()
+	defer service.outboxMu.Unlock()
+	if service.outbox[key].message != message {
+		return false
+	}
+	delete(service.outbox, key)
+	return true
+}
+
+func (service *Service) getSendDelay() time.Duration {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	return service.sendDelay
+}
+
+// sendQueued passes a message in the outbox to the user's provider, unless
+// it was retracted or replaced by a correction while it was queued
+func (service *Service) sendQueued(ctx context.Context, user user, key outboxKey, message *Message) error {
+	if err := service.prepareOutboundMedia(ctx, user.provider, message); err != nil {
+		if !service.dequeueOutbound(key, message) {
+			return nil
+		}
+		return errors.New("Sending MMS failed: " + err.Error())
+	}
+	if !service.dequeueOutbound(key, message) {
+		return nil
+	}
+	err := user.provider.Send(ctx, message)
+	service.recordSend(user.providerName, err)
+	if err != nil {
+		return errors.New("Sending SMS failed: " + err.Error())
+	}
+	return nil
+}
+
+// replaceQueued puts the corrected message in the outbox in place of the
+// message it corrects, if that message is still there.  The goroutine sending
+// the replaced message will find that it's no longer in the outbox and drop
+// it, so the corrected message (including any changed media) is sent instead.
+func (service *Service) replaceQueued(key outboxKey, message *Message) bool {
+	if key.id == "" {
+		return false
+	}
+	service.outboxMu.Lock()
+	defer service.outboxMu.Unlock()
+	entry, queued := service.outbox[key]
+	if !queued {
+		return false
+	}
+	service.recordOutbound(key, message)
+	if entry.correction {
+		message.Body = correctionPrefix + message.Body
+	}
+	service.outbox[key] = outboxEntry{message: message, correction: entry.correction}
+	return true
+}
+
+// retractQueued removes the message from the outbox so it won't be sent
+func (service *Service) retractQueued(key outboxKey) bool {
+	if key.id == "" {
+		return false
+	}
+	service.outboxMu.Lock()
+	defer service.outboxMu.Unlock()
+	if _, queued := service.outbox[key]; !queued {
+		return false
+	}
+	delete(service.outbox, key)
+	return true
+}
+
+func (service *Service) receiveXMPPRetraction(xmppMessage *messageStanza) error {
+	if _, userExists := service.lookupUser(*xmppMessage.From.Bare()); !userExists {
+		return nil
+	}
+	key, ok := service.makeOutboxKey(xmppMessage)
+	key.id = xmppMessage.Retract.ID
+	if ok && service.retractQueued(key) {
+		return nil
+	}
+	return service.sendXMPPError(xmppMessage.To, xmppMessage.From, "Your message could not be retracted because it has already been sent as an SMS to "+xmppMessage.To.LocalPart+", and SMS can't be unsent")
+}
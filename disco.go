@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"context"
+	"errors"
+
+	"src.agwa.name/go-xmpp"
+)
+
+const (
+	discoInfoNS  = "http://jabber.org/protocol/disco#info"
+	discoItemsNS = "http://jabber.org/protocol/disco#items"
+)
+
+func (service *Service) discoFeatures(from xmpp.Address, to xmpp.Address) []string {
+	features := []string{discoInfoNS, discoItemsNS}
+	if to.LocalPart == "" {
+		features = append(features, commandsNS)
+	} else {
+		features = append(features, correctionNS, retractionNS, reactionsNS, replyNS, vcardTempNS, vcard4NS)
+		if service.supportsChatStates(from) {
+			features = append(features, chatStatesNS)
+		}
+	}
+	return features
+}
+
+func (service *Service) receiveXMPPDiscoInfo(ctx context.Context, iq *iqStanza) error {
+	if iq.From == nil || iq.To == nil {
+		return errors.New("Received malformed XMPP iq: From and To not set")
+	}
+	if iq.Type != "get" {
+		return nil
+	}
+
+	info := &discoInfo{Node: iq.DiscoInfo.Node}
+	if iq.To.LocalPart == "" {
+		info.Identities = []discoIdentity{{Category: "gateway", Type: "sms", Name: "SMS"}}
+	} else {
+		info.Identities = []discoIdentity{{Category: "client", Type: "sms"}}
+	}
+	for _, feature := range service.discoFeatures(*iq.From, *iq.To) {
+		info.Features = append(info.Features, discoFeature{Var: feature})
+	}
+
+	reply := makeIqReply(iq, "result")
+	reply.DiscoInfo = info
+	return service.sendXMPPIq(reply)
+}
+
+func (service *Service) receiveXMPPDiscoItems(ctx context.Context, iq *iqStanza) error {
+	if iq.From == nil || iq.To == nil {
+		return errors.New("Received malformed XMPP iq: From and To not set")
+	}
+	if iq.Type != "get" {
+		return nil
+	}
+
+	items := &discoItems{Node: iq.DiscoItems.Node}
+	if iq.DiscoItems.Node == commandsNS && iq.To.LocalPart == "" && service.isAdmin(*iq.From) {
+		for _, command := range adminCommands {
+			items.Items = append(items.Items, discoItem{
+				JID:  *iq.To,
+				Node: command.node,
+				Name: command.name,
+			})
+		}
+	}
+
+	reply := makeIqReply(iq, "result")
+	reply.DiscoItems = items
+	return service.sendXMPPIq(reply)
+}
+
+func makeIqReply(iq *iqStanza, iqType string) *iqStanza {
+	return &iqStanza{
+		Header: xmpp.Header{
+			From: iq.To,
+			To:   iq.From,
+			ID:   iq.ID,
+		},
+		Type: iqType,
+	}
+}
+
+func makeIqError(iq *iqStanza, errorType string, condition string) *iqStanza {
+	reply := makeIqReply(iq, "error")
+	reply.Error = &stanzaError{Type: errorType, Condition: condition}
+	return reply
+}
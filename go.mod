@@ -5,13 +5,26 @@ go 1.24.4
 require (
 	github.com/emersion/go-vcard v0.0.0-20230815062825-8fda7d206ec9
 	github.com/emersion/go-webdav v0.4.0
+	github.com/gen2brain/heic v0.4.5
+	github.com/go-ldap/ldap/v3 v3.4.12
+	github.com/ttacon/libphonenumber v1.2.1
+	golang.org/x/image v0.25.0
 	golang.org/x/sync v0.15.0
+	golang.org/x/text v0.26.0
+	gopkg.in/yaml.v3 v3.0.1
 	src.agwa.name/go-listener v0.7.0
 	src.agwa.name/go-xmpp v0.1.1
 )
 
 require (
+	github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358 // indirect
+	github.com/ebitengine/purego v0.8.3 // indirect
+	github.com/go-asn1-ber/asn1-ber v1.5.8-0.20250403174932-29230038a667 // indirect
+	github.com/golang/protobuf v1.5.4 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/tetratelabs/wazero v1.9.0 // indirect
+	github.com/ttacon/builder v0.0.0-20170518171403-c099f663e1c2 // indirect
 	golang.org/x/crypto v0.39.0 // indirect
 	golang.org/x/net v0.41.0 // indirect
-	golang.org/x/text v0.26.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"sync"
+
+	"src.agwa.name/go-xmpp"
+)
+
+// maxHistory is the number of recent messages remembered for each
+// conversation, so that reactions and replies can refer to them
+const maxHistory = 100
+
+type conversation struct {
+	user    xmpp.Address // bare JID of the user
+	contact string       // canonical phone number of the contact
+}
+
+// makeConversation returns the conversation between the user and the
+// contact's JID.  The contact is identified by canonical phone number, so
+// that JIDs which spell the same number differently share a history.
+func (service *Service) makeConversation(user xmpp.Address, contact xmpp.Address) (conversation, bool) {
+	phoneNumber, err := service.canonPhoneNumber(contact.LocalPart)
+	if err != nil {
+		return conversation{}, false
+	}
+	return conversation{user: *user.Bare(), contact: phoneNumber}, true
+}
+
+type historyEntry struct {
+	id               string // XMPP message ID
+	fromUser         bool   // true if the user sent the message, false if the contact did
+	body             string
+	attachment       bool     // true if the message is an attachment, in which case body is the URL
+	userReactions    []string // the user's current reactions to this message
+	contactReactions []string // the contact's current reactions to this message
+}
+
+type messageHistory struct {
+	mu            sync.Mutex
+	conversations map[conversation][]*historyEntry // oldest first
+}
+
+func (history *messageHistory) add(conv conversation, entry *historyEntry) {
+	if entry.id == "" {
+		return
+	}
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	if history.conversations == nil {
+		history.conversations = make(map[conversation][]*historyEntry)
+	}
+	entries := append(history.conversations[conv], entry)
+	if len(entries) > maxHistory {
+		entries = entries[len(entries)-maxHistory:]
+	}
+	history.conversations[conv] = entries
+}
+
+// find returns the most recent entry in the conversation for which match
+// returns true.  The entry must only be accessed using history.update.
+func (history *messageHistory) find(conv conversation, match func(*historyEntry) bool) *historyEntry {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	entries := history.conversations[conv]
+	for i := len(entries) - 1; i >= 0; i-- {
+		if match(entries[i]) {
+			return entries[i]
+		}
+	}
+	return nil
+}
+
+func (history *messageHistory) findByID(conv conversation, id string) *historyEntry {
+	return history.find(conv, func(entry *historyEntry) bool { return entry.id == id })
+}
+
+// recordInbound remembers a message sent from a contact JID to the user
+func (service *Service) recordInbound(from xmpp.Address, to xmpp.Address, entry *historyEntry) {
+	if conv, ok := service.makeConversation(to, from); ok {
+		service.history.add(conv, entry)
+	}
+}
+
+// recordOutbound remembers a message sent by the user.  If the message is a
+// correction of a message still in the outbox, the original message's entry
+// is updated instead.
+func (service *Service) recordOutbound(key outboxKey, message *Message) {
+	conv := key.conversation
+	if entry := service.history.findByID(conv, key.id); entry != nil {
+		service.history.update(func() { entry.body = message.Body })
+		return
+	}
+	service.history.add(conv, &historyEntry{
+		id:         key.id,
+		fromUser:   true,
+		body:       message.Body,
+		attachment: message.Body == "" && len(message.MediaURLs) > 0,
+	})
+}
+
+// update calls f with the history locked, so that f can safely modify entries
+func (history *messageHistory) update(f func()) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	f()
+}
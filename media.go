@@ -0,0 +1,301 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"src.agwa.name/go-xmpp"
+)
+
+const (
+	maxMediaSize = 100 * 1024 * 1024
+
+	outboundMediaSubdir    = "outbound"     // subdirectory of media_dir containing converted outbound media
+	outboundMediaRetention = 24 * time.Hour // how long to keep converted outbound media for the provider to fetch
+	mediaCleanupInterval   = time.Hour
+)
+
+type mediaFile struct {
+	filename    string
+	contentType string
+	data        []byte
+}
+
+func (service *Service) mediaRehostingEnabled() bool {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	return service.mediaUploadService != nil || service.mediaDir != ""
+}
+
+// receiveMedia re-hosts inbound media so that clients receive a stable URL
+// which doesn't reveal the provider account or require the provider's
+// credentials.  If re-hosting fails, the provider's URL is sent instead.
+func (service *Service) receiveMedia(from xmpp.Address, to xmpp.Address, provider Provider, body string, mediaURLs []string) {
+	attachments := make([]attachment, len(mediaURLs))
+	for i, mediaURL := range mediaURLs {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		attachment, err := service.rehostMedia(ctx, provider, mediaURL)
+		cancel()
+		if err != nil {
+			log.Printf("Unable to re-host media from %s for %s (sending original URL instead): %s", from, to, err)
+			attachment.url = mediaURL
+		}
+		attachments[i] = attachment
+	}
+	if err := service.sendXMPPMMS(from, to, body, attachments); err != nil {
+		log.Printf("Unable to send media from %s to %s: %s", from, to, err)
+	}
+}
+
+func (service *Service) rehostMedia(ctx context.Context, provider Provider, mediaURL string) (attachment, error) {
+	file, err := downloadMedia(ctx, http.DefaultClient, provider, mediaURL, maxMediaSize)
+	if err != nil {
+		return attachment{}, fmt.Errorf("error downloading media: %w", err)
+	}
+
+	service.mu.RLock()
+	uploadService, mediaDir, publicURL := service.mediaUploadService, service.mediaDir, service.publicURL
+	service.mu.RUnlock()
+
+	var rehostedURL string
+	if uploadService != nil {
+		rehostedURL, err = service.uploadMedia(ctx, *uploadService, file)
+	} else {
+		rehostedURL, err = storeMedia(mediaDir, strings.TrimSuffix(publicURL, "/")+"/media", file)
+	}
+	if err != nil {
+		return attachment{}, err
+	}
+	return attachment{
+		url:         rehostedURL,
+		contentType: file.contentType,
+		filename:    file.filename,
+		size:        int64(len(file.data)),
+	}, nil
+}
+
+func downloadMedia(ctx context.Context, client *http.Client, provider Provider, mediaURL string, maxSize int) (*mediaFile, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", mediaURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if authenticator, ok := provider.(MediaAuthenticator); ok {
+		authenticator.AuthenticateMediaRequest(req)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if !(resp.StatusCode >= 200 && resp.StatusCode <= 299) {
+		return nil, fmt.Errorf("HTTP error: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("media is larger than %d bytes", maxSize)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return &mediaFile{
+		filename:    mediaFilename(resp.Request.URL, contentType),
+		contentType: contentType,
+		data:        data,
+	}, nil
+}
+
+// mediaFilename returns a safe filename for the media, with an extension
+// matching its content type
+func mediaFilename(mediaURL *url.URL, contentType string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return -1
+	}, path.Base(mediaURL.Path))
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		name = "media"
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if extensions, _ := mime.ExtensionsByType(mediaType); len(extensions) > 0 && mime.TypeByExtension(path.Ext(name)) == "" {
+			name += extensions[0]
+		}
+	}
+	return name
+}
+
+// uploadMedia uploads the file to the XMPP server's HTTP File Upload service (XEP-0363)
+func (service *Service) uploadMedia(ctx context.Context, uploadService xmpp.Address, file *mediaFile) (string, error) {
+	request := &iqStanza{
+		Header: xmpp.Header{
+			From: &xmpp.Address{DomainPart: service.xmppParams.Domain},
+			To:   &uploadService,
+		},
+		Type: "get",
+		UploadRequest: &uploadRequest{
+			Filename:    file.filename,
+			Size:        int64(len(file.data)),
+			ContentType: file.contentType,
+		},
+	}
+	response, err := service.sendXMPPIqAndWait(ctx, request)
+	if err != nil {
+		return "", fmt.Errorf("error requesting upload slot from %s: %w", uploadService, err)
+	}
+	if response.UploadSlot == nil {
+		return "", fmt.Errorf("%s did not return an upload slot", uploadService)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", response.UploadSlot.Put.URL, bytes.NewReader(file.data))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = int64(len(file.data))
+	req.Header.Set("Content-Type", file.contentType)
+	for _, header := range response.UploadSlot.Put.Headers {
+		switch http.CanonicalHeaderKey(header.Name) {
+		case "Authorization", "Cookie", "Expires": // the only headers permitted by XEP-0363
+			req.Header.Set(header.Name, strings.TrimSpace(header.Value))
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if !(resp.StatusCode >= 200 && resp.StatusCode <= 299) {
+		return "", fmt.Errorf("HTTP error from upload service: %s", resp.Status)
+	}
+	return response.UploadSlot.Get.URL, nil
+}
+
+// storeMedia stores the file in dir under a random name, to be served by the
+// handler returned by makeMediaHandler under baseURL
+func storeMedia(dir string, baseURL string, file *mediaFile) (string, error) {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(randomBytes)
+	if err := os.MkdirAll(filepath.Join(dir, id), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, id, file.filename), file.data, 0644); err != nil {
+		return "", err
+	}
+	return baseURL + "/" + id + "/" + url.PathEscape(file.filename), nil
+}
+
+// isMediaID reports whether name is the name of a directory created by storeMedia
+func isMediaID(name string) bool {
+	_, err := hex.DecodeString(name)
+	return len(name) == 32 && err == nil
+}
+
+// RunMediaCleanup periodically deletes converted outbound media from
+// media_dir once the provider no longer needs it, and re-hosted inbound media
+// once it is older than media_retention days, if set
+func (service *Service) RunMediaCleanup(ctx context.Context) error {
+	ticker := time.NewTicker(mediaCleanupInterval)
+	defer ticker.Stop()
+	for {
+		service.mu.RLock()
+		mediaDir, retention := service.mediaDir, service.mediaRetention
+		service.mu.RUnlock()
+
+		if mediaDir != "" {
+			removeOldMedia(filepath.Join(mediaDir, outboundMediaSubdir), outboundMediaRetention)
+			if retention > 0 {
+				removeOldMedia(mediaDir, retention)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func removeOldMedia(dir string, maxAge time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Unable to clean up media: %s", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !isMediaID(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			log.Printf("Unable to clean up media: %s", err)
+		}
+	}
+}
+
+func makeMediaHandler(mediaDir string) http.Handler {
+	fileServer := http.FileServer(http.Dir(mediaDir))
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/") {
+			// Don't allow directories to be listed, since that would let anyone enumerate all media
+			http.Error(w, "404 Not Found", 404)
+			return
+		}
+		fileServer.ServeHTTP(w, req)
+	})
+}
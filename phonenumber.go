@@ -0,0 +1,177 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ttacon/libphonenumber"
+)
+
+var errNoDefaultPrefix = errors.New("does not start with + (please prefix number with + and a country code, or configure the default_prefix option)")
+
+// prefixRegion returns the main region, such as "US" or "GB", if defaultPrefix
+// is a country calling code (e.g. "+1" or "+44").  National numbers are parsed
+// according to the conventions of this region, including its international
+// and trunk prefixes.  Otherwise, such as when defaultPrefix also contains an
+// area code (e.g. "+1212"), the unknown region "ZZ" is returned.
+func prefixRegion(defaultPrefix string) string {
+	countryCode, err := strconv.Atoi(strings.TrimPrefix(defaultPrefix, "+"))
+	if err != nil || !strings.HasPrefix(defaultPrefix, "+") {
+		return libphonenumber.UNKNOWN_REGION
+	}
+	return libphonenumber.GetRegionCodeForCountryCode(countryCode)
+}
+
+// checkDefaultPrefix returns an error if prefix isn't + followed by digits,
+// such as a country calling code ("+1") or a country calling code and area
+// code ("+1212")
+func checkDefaultPrefix(prefix string) error {
+	if !strings.HasPrefix(prefix, "+") {
+		return errors.New("does not start with +")
+	}
+	if prefix == "+" {
+		return errors.New("does not contain a country calling code")
+	}
+	for _, c := range strings.TrimPrefix(prefix, "+") {
+		if !(c >= '0' && c <= '9') {
+			return errors.New("contains non-numeric character")
+		}
+	}
+	return nil
+}
+
+// parsePhoneNumber converts a phone number to E.164.  If defaultPrefix is a
+// country calling code, numbers which don't start with + are interpreted
+// according to the conventions of that country, so "(212) 555-1212" and
+// "011 44 20 7946 0958" are understood if defaultPrefix is "+1", and
+// "020 7946 0958" and "00 1 212 555 1212" if it's "+44".  Any other
+// defaultPrefix, such as "+1212", is simply prepended.  Punctuation, tel:
+// URIs, and extensions are allowed.  If the number has the wrong length for
+// its country, the error explains why.
+func parsePhoneNumber(phoneNumber string, defaultPrefix string) (string, error) {
+	region := prefixRegion(defaultPrefix)
+	if region == libphonenumber.UNKNOWN_REGION && !strings.HasPrefix(strings.TrimPrefix(strings.TrimSpace(phoneNumber), "tel:"), "+") {
+		if defaultPrefix == "" {
+			return "", errNoDefaultPrefix
+		}
+		phoneNumber = defaultPrefix + strings.TrimPrefix(strings.TrimSpace(phoneNumber), "tel:")
+	}
+	number, err := libphonenumber.Parse(phoneNumber, region)
+	if err != nil {
+		return "", describeParseError(err)
+	}
+	if err := checkPhoneNumber(number); err != nil {
+		return "", err
+	}
+	return libphonenumber.Format(number, libphonenumber.E164), nil
+}
+
+func describeParseError(err error) error {
+	switch err {
+	case libphonenumber.ErrInvalidCountryCode:
+		return errors.New("has an invalid country code")
+	case libphonenumber.ErrNotANumber:
+		return errors.New("is not a phone number")
+	case libphonenumber.ErrTooShortNSN, libphonenumber.ErrTooShortAfterIDD:
+		return errors.New("is too short")
+	case libphonenumber.ErrNumTooLong:
+		return errors.New("is too long")
+	default:
+		return err
+	}
+}
+
+// checkPhoneNumber returns an error if the number has the wrong length for
+// its country.  Numbers aren't checked against the ranges currently in use,
+// since the metadata for them lags behind new allocations.
+func checkPhoneNumber(number *libphonenumber.PhoneNumber) error {
+	countryCode := number.GetCountryCode()
+	switch libphonenumber.IsPossibleNumberWithReason(number) {
+	case libphonenumber.INVALID_COUNTRY_CODE:
+		return fmt.Errorf("has an invalid country code (+%d)", countryCode)
+	case libphonenumber.TOO_SHORT:
+		return fmt.Errorf("is too short for a number with country code +%d", countryCode)
+	case libphonenumber.TOO_LONG:
+		return fmt.Errorf("is too long for a number with country code +%d", countryCode)
+	}
+	return nil
+}
+
+// validateE164 returns an error if phoneNumber isn't a valid number in E.164
+// format, such as +12125551212
+func validateE164(phoneNumber string) error {
+	if !strings.HasPrefix(phoneNumber, "+") {
+		return errors.New("does not start with +")
+	}
+	for _, c := range strings.TrimPrefix(phoneNumber, "+") {
+		if !(c >= '0' && c <= '9') {
+			return errors.New("contains non-numeric character")
+		}
+	}
+	_, err := parsePhoneNumber(phoneNumber, "")
+	return err
+}
+
+// formatPhoneNumber formats an E.164 phone number for display, in
+// international format, e.g. "+1 212-555-1212" or "+44 20 7946 0958"
+func formatPhoneNumber(phoneNumber string) string {
+	number, err := libphonenumber.Parse(phoneNumber, libphonenumber.UNKNOWN_REGION)
+	if err != nil {
+		return phoneNumber
+	}
+	return libphonenumber.Format(number, libphonenumber.INTERNATIONAL)
+}
+
+// localPhoneNumber returns phoneNumber without defaultPrefix, e.g.
+// "2125551212" for +12125551212 if defaultPrefix is "+1", or "2079460958" for
+// +442079460958 if it's "+44".  If defaultPrefix is a country calling code,
+// this is the national significant number, without any trunk prefix.  Numbers
+// from other countries, and senders which aren't phone numbers (such as short
+// codes and alphanumeric sender IDs), are returned unchanged, as are numbers
+// whose local form wouldn't parse back to the same number.
+func localPhoneNumber(phoneNumber string, defaultPrefix string) string {
+	if defaultPrefix == "" || !strings.HasPrefix(phoneNumber, defaultPrefix) {
+		return phoneNumber
+	}
+	local := strings.TrimPrefix(phoneNumber, defaultPrefix)
+	if region := prefixRegion(defaultPrefix); region != libphonenumber.UNKNOWN_REGION {
+		number, err := libphonenumber.Parse(phoneNumber, region)
+		if err != nil || int(number.GetCountryCode()) != libphonenumber.GetCountryCodeForRegion(region) {
+			return phoneNumber
+		}
+		local = libphonenumber.GetNationalSignificantNumber(number)
+	}
+	if parsed, err := parsePhoneNumber(local, defaultPrefix); err != nil || parsed != phoneNumber {
+		return phoneNumber
+	}
+	return local
+}
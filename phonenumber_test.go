@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"testing"
+)
+
+func TestParsePhoneNumber(t *testing.T) {
+	tests := []struct {
+		phoneNumber   string
+		defaultPrefix string
+		want          string // "" if an error is expected
+	}{
+		{"+12125551212", "", "+12125551212"},
+		{"+1 (212) 555-1212", "", "+12125551212"},
+		{"tel:+1-212-555-1212", "", "+12125551212"},
+		{"2125551212", "", ""},
+		{"2125551212", "+1", "+12125551212"},
+		{"12125551212", "+1", "+12125551212"},
+		{"(212) 555-1212", "+1", "+12125551212"},
+		{"011 44 20 7946 0958", "+1", "+442079460958"},
+		{"020 7946 0958", "+44", "+442079460958"},
+		{"2079460958", "+44", "+442079460958"},
+		{"00 1 212 555 1212", "+44", "+12125551212"},
+		{"0612345678", "+39", "+390612345678"},
+		{"5551212", "+1212", "+12125551212"},
+		{"+442079460958", "+1212", "+442079460958"},
+		{"+19995551212", "", "+19995551212"}, // possible, though not in an area code in use
+		{"+1212555121", "", ""},
+		{"+121255512123", "", ""},
+		{"555", "+1", ""},
+		{"+999123456", "", ""},
+		{"hello", "+1", ""},
+	}
+	for _, test := range tests {
+		got, err := parsePhoneNumber(test.phoneNumber, test.defaultPrefix)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("parsePhoneNumber(%q, %q) = %q, want error", test.phoneNumber, test.defaultPrefix, got)
+			}
+		} else if err != nil || got != test.want {
+			t.Errorf("parsePhoneNumber(%q, %q) = (%q, %v), want %q", test.phoneNumber, test.defaultPrefix, got, err, test.want)
+		}
+	}
+}
+
+func TestLocalPhoneNumber(t *testing.T) {
+	tests := []struct {
+		phoneNumber   string
+		defaultPrefix string
+		want          string
+	}{
+		{"+12125551212", "+1", "2125551212"},
+		{"+12125551212", "", "+12125551212"},
+		{"+12125551212", "+44", "+12125551212"},
+		{"+442079460958", "+44", "2079460958"},
+		{"+442079460958", "+1", "+442079460958"},
+		{"+390612345678", "+39", "0612345678"}, // the leading 0 is part of Italian numbers
+		{"+12125551212", "+1212", "5551212"},
+		{"+13105551212", "+1212", "+13105551212"},
+		{"12345", "+1", "12345"},
+		{"ACME", "+1", "ACME"},
+	}
+	for _, test := range tests {
+		got := localPhoneNumber(test.phoneNumber, test.defaultPrefix)
+		if got != test.want {
+			t.Errorf("localPhoneNumber(%q, %q) = %q, want %q", test.phoneNumber, test.defaultPrefix, got, test.want)
+			continue
+		}
+		// The local form must be addressable, so it has to parse back to the same number
+		if got != test.phoneNumber {
+			if parsed, err := parsePhoneNumber(got, test.defaultPrefix); err != nil || parsed != test.phoneNumber {
+				t.Errorf("parsePhoneNumber(%q, %q) = (%q, %v), want %q", got, test.defaultPrefix, parsed, err, test.phoneNumber)
+			}
+		}
+	}
+}
+
+func TestCheckDefaultPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		ok     bool
+	}{
+		{"+1", true},
+		{"+44", true},
+		{"+1212", true},
+		{"1", false},
+		{"+", false},
+		{"+1-212", false},
+	}
+	for _, test := range tests {
+		if err := checkDefaultPrefix(test.prefix); (err == nil) != test.ok {
+			t.Errorf("checkDefaultPrefix(%q) = %v, want ok=%v", test.prefix, err, test.ok)
+		}
+	}
+}
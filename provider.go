@@ -30,7 +30,11 @@ package smsxmpp
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -40,25 +44,200 @@ type Provider interface {
 	HTTPHandler() http.Handler
 }
 
+// MediaAuthenticator is implemented by providers whose inbound media URLs can
+// only be downloaded using the provider's credentials.
+type MediaAuthenticator interface {
+	AuthenticateMediaRequest(*http.Request)
+}
+
+// MediaLimits describes the outbound media which a provider can send
+type MediaLimits struct {
+	MaxSize      int      // maximum size of each attachment in bytes
+	ContentTypes []string // content types which can be sent; if nil, any type can be sent
+}
+
+func (limits *MediaLimits) accepts(contentType string) bool {
+	if limits.ContentTypes == nil {
+		return true
+	}
+	for _, acceptedType := range limits.ContentTypes {
+		if acceptedType == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// MediaLimiter is implemented by providers which limit the size or type of
+// outbound media.  When media_dir is configured, sms-over-xmpp fetches outbound
+// media, converts images which exceed the limits, and gives the provider a URL
+// for the converted file.
+type MediaLimiter interface {
+	MediaLimits() MediaLimits
+}
+
+// TypingNotifier is implemented by providers, such as RCS-capable backends,
+// which can tell the recipient that the user is typing.  Such providers
+// should call Service.ReceiveTyping when the other party is typing.
+type TypingNotifier interface {
+	SendTyping(ctx context.Context, from string, to string, typing bool) error
+}
+
+// CallerNameLooker is implemented by providers which can look up the caller
+// name (CNAM) registered for a phone number, such as Twilio with the
+// caller_name_lookup parameter.  LookupCallerName returns "" if the number
+// has no caller name.
+type CallerNameLooker interface {
+	LookupCallerName(ctx context.Context, phoneNumber string) (string, error)
+}
+
 type ProviderConfig map[string]string
+
+// MaxMediaSize returns the value of the max_media_size parameter, which
+// providers implementing MediaLimiter should declare as a PositiveIntParam,
+// or defaultSize if the parameter isn't set
+func (config ProviderConfig) MaxMediaSize(defaultSize int) (int, error) {
+	value := config["max_media_size"]
+	if value == "" {
+		return defaultSize, nil
+	}
+	maxMediaSize, err := strconv.Atoi(value)
+	if err != nil || maxMediaSize <= 0 {
+		return 0, errors.New("max_media_size must be a positive integer")
+	}
+	return maxMediaSize, nil
+}
+
+type ParamType int
+
+const (
+	StringParam ParamType = iota
+	IntParam
+	PositiveIntParam
+	BoolParam
+	URLParam
+	HostnameParam
+)
+
+func (paramType ParamType) String() string {
+	switch paramType {
+	case IntParam:
+		return "integer"
+	case PositiveIntParam:
+		return "positive integer"
+	case BoolParam:
+		return "boolean"
+	case URLParam:
+		return "URL"
+	case HostnameParam:
+		return "hostname"
+	default:
+		return "string"
+	}
+}
+
+func (paramType ParamType) check(value string) error {
+	switch paramType {
+	case IntParam:
+		if _, err := strconv.Atoi(value); err != nil {
+			return errors.New("must be an integer")
+		}
+	case PositiveIntParam:
+		if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+			return errors.New("must be a positive integer")
+		}
+	case BoolParam:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return errors.New("must be true or false")
+		}
+	case URLParam:
+		if err := checkHTTPURL(value); err != nil {
+			return err
+		}
+	case HostnameParam:
+		if value == "" || strings.ContainsAny(value, "/:@ ") {
+			return errors.New("must be a hostname")
+		}
+	}
+	return nil
+}
+
+type ParamSchema struct {
+	Name        string
+	Type        ParamType
+	Required    bool
+	Secret      bool // if true, the value should be kept out of the config using a ${...} reference
+	Description string
+}
+
+// ProviderSchema describes the parameters accepted by a type of provider.
+// MakeProvider checks the config against the schema before calling the
+// provider's MakeProviderFunc, which may therefore assume that required
+// parameters are present and well-formed.
+type ProviderSchema struct {
+	Description string
+	Params      []ParamSchema
+}
+
+func (schema *ProviderSchema) Check(config ProviderConfig) error {
+	var errs []error
+	known := make(map[string]bool)
+	for _, param := range schema.Params {
+		known[param.Name] = true
+		value, exists := config[param.Name]
+		if !exists || value == "" {
+			if param.Required {
+				errs = append(errs, fmt.Errorf("required parameter %s is missing", param.Name))
+			}
+			continue
+		}
+		if err := param.Type.check(value); err != nil {
+			errs = append(errs, fmt.Errorf("parameter %s %s", param.Name, err))
+		}
+	}
+	var unknown []string
+	for name := range config {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs = append(errs, fmt.Errorf("unknown parameter %s", name))
+	}
+	return errors.Join(errs...)
+}
+
+// A MakeProviderFunc may be passed a nil *Service when the configuration is
+// only being checked, so it must not do anything besides validating the
+// config and constructing the Provider.
 type MakeProviderFunc func(*Service, ProviderConfig) (Provider, error)
 
+type providerType struct {
+	schema       ProviderSchema
+	makeProvider MakeProviderFunc
+}
+
 var (
 	providerTypesMu sync.RWMutex
-	providerTypes   = make(map[string]MakeProviderFunc)
+	providerTypes   = make(map[string]providerType)
 )
 
 func MakeProvider(typeName string, service *Service, config ProviderConfig) (Provider, error) {
 	providerTypesMu.RLock()
-	makeProvider, exists := providerTypes[typeName]
+	providerType, exists := providerTypes[typeName]
 	providerTypesMu.RUnlock()
 
 	if !exists {
 		return nil, errors.New("Invalid provider type " + typeName)
 	}
-	return makeProvider(service, config)
+	if err := providerType.schema.Check(config); err != nil {
+		return nil, err
+	}
+	return providerType.makeProvider(service, config)
 }
-func RegisterProviderType(name string, makeProvider MakeProviderFunc) {
+
+func RegisterProviderType(name string, schema ProviderSchema, makeProvider MakeProviderFunc) {
 	providerTypesMu.Lock()
 	defer providerTypesMu.Unlock()
 	if makeProvider == nil {
@@ -67,5 +246,22 @@ func RegisterProviderType(name string, makeProvider MakeProviderFunc) {
 	if _, alreadyExists := providerTypes[name]; alreadyExists {
 		panic("smsxmpp.RegisterProviderType: called twice for type " + name)
 	}
-	providerTypes[name] = makeProvider
+	providerTypes[name] = providerType{
+		schema:       schema,
+		makeProvider: makeProvider,
+	}
+}
+
+// ProviderTypes returns the names of all registered provider types in sorted order.
+func ProviderTypes() []string {
+	providerTypesMu.RLock()
+	defer providerTypesMu.RUnlock()
+	return sortedKeys(providerTypes)
+}
+
+func GetProviderSchema(typeName string) (ProviderSchema, bool) {
+	providerTypesMu.RLock()
+	defer providerTypesMu.RUnlock()
+	providerType, exists := providerTypes[typeName]
+	return providerType.schema, exists
 }
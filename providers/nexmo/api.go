@@ -39,9 +39,13 @@ import (
 
 // Inbound SMS request as specified at https://developer.nexmo.com/api/sms#inbound-sms
 type inboundSMS struct {
-	Msisdn string `json:"msisdn"`
-	To     string `json:"to"`
-	Text   string `json:"text"`
+	Msisdn      string `json:"msisdn"`
+	To          string `json:"to"`
+	Text        string `json:"text"`
+	Concat      string `json:"concat"`       // "true" if this is one part of a concatenated message
+	ConcatRef   string `json:"concat-ref"`   // identifies the concatenated message
+	ConcatTotal string `json:"concat-total"` // the number of parts in the concatenated message
+	ConcatPart  string `json:"concat-part"`  // the number of this part, starting from 1
 }
 
 // Response to sending an SMS as specified at https://developer.nexmo.com/api/sms#send-an-sms
@@ -35,14 +35,21 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"src.agwa.name/sms-over-xmpp"
 	"src.agwa.name/sms-over-xmpp/httputil"
+	"src.agwa.name/sms-over-xmpp/smsencoding"
 )
 
+// Nexmo delivers each part of a concatenated inbound SMS in a separate webhook
+const reassemblyTimeout = 2 * time.Minute
+
 type Provider struct {
-	service *smsxmpp.Service
+	service     *smsxmpp.Service
+	reassembler *smsxmpp.Reassembler
 
 	apiKey       string
 	apiSecret    string
@@ -61,7 +68,7 @@ func (provider *Provider) Send(ctx context.Context, message *smsxmpp.Message) er
 	request.Set("from", strings.TrimPrefix(message.From, "+"))
 	request.Set("to", strings.TrimPrefix(message.To, "+"))
 	request.Set("text", message.Body)
-	if !isASCII(message.Body) {
+	if smsencoding.Analyze(message.Body).Encoding == smsencoding.UCS2 {
 		// TODO: test non-ASCII messages
 		request.Set("type", "unicode")
 	}
@@ -112,7 +119,22 @@ func (provider *Provider) handleInboundSMS(w http.ResponseWriter, req *http.Requ
 		To:   "+" + inboundSMS.To,
 		Body: inboundSMS.Text,
 	}
-	if err := provider.service.Receive(&message); err != nil {
+	if inboundSMS.Concat == "true" {
+		part, partErr := strconv.Atoi(inboundSMS.ConcatPart)
+		total, totalErr := strconv.Atoi(inboundSMS.ConcatTotal)
+		if partErr != nil || totalErr != nil {
+			http.Error(w, "400 Bad Request: malformed concat-part or concat-total", 400)
+			return
+		}
+		err = provider.reassembler.Receive(&message, inboundSMS.ConcatRef, part, total)
+		if errors.Is(err, smsxmpp.ErrInvalidConcatPart) {
+			http.Error(w, "400 Bad Request: "+err.Error(), 400)
+			return
+		}
+	} else {
+		err = provider.service.Receive(&message)
+	}
+	if err != nil {
 		// TODO: log the error
 		http.Error(w, "500 Internal Server Error: failed to receive message", 500)
 		return
@@ -123,12 +145,22 @@ func (provider *Provider) handleInboundSMS(w http.ResponseWriter, req *http.Requ
 func MakeProvider(service *smsxmpp.Service, config smsxmpp.ProviderConfig) (smsxmpp.Provider, error) {
 	return &Provider{
 		service:      service,
+		reassembler:  smsxmpp.NewReassembler(service, reassemblyTimeout),
 		apiKey:       config["api_key"],
 		apiSecret:    config["api_secret"],
 		httpPassword: config["http_password"],
 	}, nil
 }
 
+var Schema = smsxmpp.ProviderSchema{
+	Description: "Nexmo/Vonage (https://www.vonage.com/communications-apis/)",
+	Params: []smsxmpp.ParamSchema{
+		{Name: "api_key", Required: true, Description: "Your Nexmo API key, provided by Nexmo"},
+		{Name: "api_secret", Required: true, Secret: true, Description: "Your Nexmo API secret, provided by Nexmo"},
+		{Name: "http_password", Secret: true, Description: "A password, chosen by you, that Nexmo must use when executing the webhook for incoming SMSes"},
+	},
+}
+
 func init() {
-	smsxmpp.RegisterProviderType("nexmo", MakeProvider)
+	smsxmpp.RegisterProviderType("nexmo", Schema, MakeProvider)
 }
@@ -37,6 +37,8 @@ import (
 	"strings"
 )
 
+const lookupURL = "https://lookups.twilio.com/v2/PhoneNumbers/"
+
 type apiResponse struct {
 	Status  string `json:"status"`
 	Message string `json:"message"`
@@ -80,3 +82,44 @@ func (provider *Provider) doTwilioRequest(ctx context.Context, service string, f
 
 	return resp, nil
 }
+
+type lookupResponse struct {
+	CallerName *struct {
+		CallerName string `json:"caller_name"`
+		ErrorCode  *int   `json:"error_code"`
+	} `json:"caller_name"`
+}
+
+// LookupCallerName returns the caller name (CNAM) of a phone number using the
+// Twilio Lookup API.  Caller names are only available for US numbers.
+func (provider callerNameProvider) LookupCallerName(ctx context.Context, phoneNumber string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", lookupURL+url.PathEscape(phoneNumber)+"?Fields=caller_name", nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(provider.keySID, provider.keySecret)
+
+	httpResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	respBytes, err := io.ReadAll(httpResp.Body)
+	httpResp.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("Error reading response from Twilio: %s", err)
+	}
+
+	if !(httpResp.StatusCode >= 200 && httpResp.StatusCode <= 299) {
+		return "", fmt.Errorf("HTTP error from Twilio: %s: %s", httpResp.Status, respBytes)
+	}
+
+	resp := new(lookupResponse)
+	if err := json.Unmarshal(respBytes, resp); err != nil {
+		return "", err
+	}
+	if resp.CallerName == nil || resp.CallerName.ErrorCode != nil {
+		return "", nil
+	}
+	return resp.CallerName.CallerName, nil
+}
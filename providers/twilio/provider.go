@@ -34,6 +34,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"unicode/utf8"
 
 	"src.agwa.name/sms-over-xmpp"
 	"src.agwa.name/sms-over-xmpp/httputil"
@@ -47,6 +48,12 @@ type Provider struct {
 	keySID       string
 	keySecret    string
 	httpPassword string
+	maxMediaSize int
+}
+
+// callerNameProvider is a Twilio provider with caller_name_lookup enabled
+type callerNameProvider struct {
+	*Provider
 }
 
 func (provider *Provider) Type() string {
@@ -59,6 +66,9 @@ func (provider *Provider) Send(ctx context.Context, message *smsxmpp.Message) er
 	request.Set("From", message.From)
 	request.Set("Body", message.Body)
 	// TODO: callback support: 1. get the public URL for this provider from the smsxmpp.Service; 2. add httpPassword to the URL; 3. set request's "StatusCallback" to URL + "/status_callback"
+	if utf8.RuneCountInString(message.Body) > 1600 {
+		return errors.New("Message too long (Twilio messages must be <= 1600 characters long)")
+	}
 	if len(message.MediaURLs) > 10 {
 		return errors.New("Too many media URLs (Twilio only supports 10 per message)")
 	} else if len(message.MediaURLs) > 0 {
@@ -69,6 +79,20 @@ func (provider *Provider) Send(ctx context.Context, message *smsxmpp.Message) er
 	return err
 }
 
+func (provider *Provider) AuthenticateMediaRequest(req *http.Request) {
+	// Media URLs are on the API host when HTTP Basic Authentication for media is enabled
+	if apiURL, err := url.Parse(provider.apiURL); err == nil && req.URL.Scheme == apiURL.Scheme && req.URL.Host == apiURL.Host {
+		req.SetBasicAuth(provider.keySID, provider.keySecret)
+	}
+}
+
+func (provider *Provider) MediaLimits() smsxmpp.MediaLimits {
+	return smsxmpp.MediaLimits{
+		MaxSize:      provider.maxMediaSize,
+		ContentTypes: supportedMediaTypes,
+	}
+}
+
 func (provider *Provider) HTTPHandler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/message", provider.handleMessage)
@@ -115,18 +139,55 @@ func getMediaURLs(form url.Values) []string {
 	return mediaURLs
 }
 
+// supportedMediaTypes are the content types which Twilio accepts and
+// delivers to all carriers; see https://www.twilio.com/docs/messaging/guides/accepted-mime-types
+var supportedMediaTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"audio/mpeg",
+	"audio/mp4",
+	"audio/amr",
+	"audio/3gpp",
+	"video/mp4",
+	"video/3gpp",
+	"video/mpeg",
+	"video/quicktime",
+	"text/vcard",
+	"text/x-vcard",
+	"text/calendar",
+	"application/pdf",
+}
+
+// Twilio rejects media larger than 5MB, but recommends keeping it under 600KB
+// for reliable delivery by carriers
+const defaultMaxMediaSize = 600 * 1024
+
 func MakeProvider(service *smsxmpp.Service, config smsxmpp.ProviderConfig) (smsxmpp.Provider, error) {
-	return &Provider{
+	maxMediaSize, err := config.MaxMediaSize(defaultMaxMediaSize)
+	if err != nil {
+		return nil, err
+	}
+	provider := &Provider{
 		service:      service,
 		apiURL:       "https://api.twilio.com",
 		accountSID:   config["account_sid"],
 		keySID:       config["key_sid"],
 		keySecret:    config["key_secret"],
 		httpPassword: config["http_password"],
-	}, nil
+		maxMediaSize: maxMediaSize,
+	}
+	if callerNameLookup, _ := strconv.ParseBool(config["caller_name_lookup"]); callerNameLookup {
+		return callerNameProvider{provider}, nil
+	}
+	return provider, nil
 }
 
 func MakeSignalwireProvider(service *smsxmpp.Service, config smsxmpp.ProviderConfig) (smsxmpp.Provider, error) {
+	maxMediaSize, err := config.MaxMediaSize(defaultMaxMediaSize)
+	if err != nil {
+		return nil, err
+	}
 	return &Provider{
 		service:      service,
 		apiURL:       "https://" + config["domain"] + "/api/laml",
@@ -134,10 +195,34 @@ func MakeSignalwireProvider(service *smsxmpp.Service, config smsxmpp.ProviderCon
 		keySID:       config["project_id"],
 		keySecret:    config["auth_token"],
 		httpPassword: config["http_password"],
+		maxMediaSize: maxMediaSize,
 	}, nil
 }
 
+var Schema = smsxmpp.ProviderSchema{
+	Description: "Twilio (https://www.twilio.com/)",
+	Params: []smsxmpp.ParamSchema{
+		{Name: "account_sid", Required: true, Description: "The main account identifier listed on your Twilio Console"},
+		{Name: "key_sid", Required: true, Description: "SID for a Twilio API key, provided by Twilio"},
+		{Name: "key_secret", Required: true, Secret: true, Description: "Secret for a Twilio API key, provided by Twilio"},
+		{Name: "http_password", Secret: true, Description: "A password, chosen by you, that Twilio must use when executing the webhook for incoming SMSes"},
+		{Name: "max_media_size", Type: smsxmpp.PositiveIntParam, Description: "Maximum size in bytes of outbound media; larger images are scaled down (default 614400)"},
+		{Name: "caller_name_lookup", Type: smsxmpp.BoolParam, Description: "Look up the caller name (CNAM) of unknown senders using Twilio Lookup, which is billed per request (default false)"},
+	},
+}
+
+var SignalwireSchema = smsxmpp.ProviderSchema{
+	Description: "SignalWire (https://signalwire.com/)",
+	Params: []smsxmpp.ParamSchema{
+		{Name: "domain", Type: smsxmpp.HostnameParam, Required: true, Description: "The domain of your SignalWire space (e.g. example.signalwire.com)"},
+		{Name: "project_id", Required: true, Description: "The ID of your SignalWire project"},
+		{Name: "auth_token", Required: true, Secret: true, Description: "Your SignalWire authentication token"},
+		{Name: "http_password", Secret: true, Description: "A password, chosen by you, that SignalWire must use when executing the webhook for incoming SMSes"},
+		{Name: "max_media_size", Type: smsxmpp.PositiveIntParam, Description: "Maximum size in bytes of outbound media; larger images are scaled down (default 614400)"},
+	},
+}
+
 func init() {
-	smsxmpp.RegisterProviderType("twilio", MakeProvider)
-	smsxmpp.RegisterProviderType("signalwire", MakeSignalwireProvider)
+	smsxmpp.RegisterProviderType("twilio", Schema, MakeProvider)
+	smsxmpp.RegisterProviderType("signalwire", SignalwireSchema, MakeSignalwireProvider)
 }
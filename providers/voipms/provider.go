@@ -40,6 +40,7 @@ import (
 
 	"src.agwa.name/sms-over-xmpp"
 	"src.agwa.name/sms-over-xmpp/httputil"
+	"src.agwa.name/sms-over-xmpp/smsencoding"
 )
 
 type Provider struct {
@@ -48,6 +49,7 @@ type Provider struct {
 	apiUsername  string
 	apiPassword  string
 	httpPassword string
+	maxMediaSize int
 }
 
 func (provider *Provider) Type() string {
@@ -81,12 +83,13 @@ func (provider *Provider) Send(ctx context.Context, message *smsxmpp.Message) er
 		request.Set("media3", message.MediaURLs[2])
 	}
 
-	if len(message.Body) <= 160 && len(message.MediaURLs) == 0 {
+	// voip.ms can't send concatenated SMS, so longer messages are sent as MMS instead
+	if info := smsencoding.Analyze(message.Body); info.Segments == 1 && len(message.MediaURLs) == 0 {
 		request.Set("method", "sendSMS")
-	} else if len(message.Body) <= 2048 && len(message.MediaURLs) <= 3 {
+	} else if info.Length <= 2048 && len(message.MediaURLs) <= 3 {
 		request.Set("method", "sendMMS")
 	} else {
-		return errors.New("Message too long (voip.ms messages must be <= 2048 bytes long and have <= 3 attachments)")
+		return errors.New("Message too long (voip.ms messages must be <= 2048 characters long and have <= 3 attachments)")
 	}
 
 	if resp, err := doRequest(ctx, request); err != nil {
@@ -98,6 +101,13 @@ func (provider *Provider) Send(ctx context.Context, message *smsxmpp.Message) er
 	return nil
 }
 
+func (provider *Provider) MediaLimits() smsxmpp.MediaLimits {
+	return smsxmpp.MediaLimits{
+		MaxSize:      provider.maxMediaSize,
+		ContentTypes: []string{"image/jpeg", "image/png", "image/gif", "video/mp4", "video/3gpp", "audio/mpeg", "audio/amr"},
+	}
+}
+
 func (provider *Provider) HTTPHandler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/sms", provider.handleSMS)
@@ -157,15 +167,33 @@ func (provider *Provider) handleSMS(w http.ResponseWriter, req *http.Request) {
 	fmt.Fprintln(w, "ok") // voip.ms requires exactly this response
 }
 
+// Larger attachments are rejected by carriers
+const defaultMaxMediaSize = 1024 * 1024
+
 func MakeProvider(service *smsxmpp.Service, config smsxmpp.ProviderConfig) (smsxmpp.Provider, error) {
+	maxMediaSize, err := config.MaxMediaSize(defaultMaxMediaSize)
+	if err != nil {
+		return nil, err
+	}
 	return &Provider{
 		service:      service,
 		apiUsername:  config["api_username"],
 		apiPassword:  config["api_password"],
 		httpPassword: config["http_password"],
+		maxMediaSize: maxMediaSize,
 	}, nil
 }
 
+var Schema = smsxmpp.ProviderSchema{
+	Description: "VoIP.ms (https://voip.ms/); can only send to/receive from numbers with +1 country code",
+	Params: []smsxmpp.ParamSchema{
+		{Name: "api_username", Required: true, Description: "Your VoIP.ms API username, provided by VoIP.ms"},
+		{Name: "api_password", Required: true, Secret: true, Description: "Your VoIP.ms API password, provided by VoIP.ms"},
+		{Name: "http_password", Secret: true, Description: "A password, chosen by you, that VoIP.ms must use when executing the webhook for incoming SMSes"},
+		{Name: "max_media_size", Type: smsxmpp.PositiveIntParam, Description: "Maximum size in bytes of outbound media; larger images are scaled down (default 1048576)"},
+	},
+}
+
 func init() {
-	smsxmpp.RegisterProviderType("voipms", MakeProvider)
+	smsxmpp.RegisterProviderType("voipms", Schema, MakeProvider)
 }
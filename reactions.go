@@ -0,0 +1,271 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"src.agwa.name/go-xmpp"
+)
+
+const reactionsNS = "urn:xmpp:reactions:0"
+
+// tapback is an iMessage reaction, which is sent to SMS recipients as text
+// such as `Liked "see you at 5"`.  Google Messages uses the same text.
+type tapback struct {
+	emoji   string
+	verb    string // used when adding the reaction
+	removal string // used when removing the reaction: `Removed a like from "..."`
+}
+
+var tapbacks = []tapback{
+	{emoji: "👍", verb: "Liked", removal: "a like"},
+	{emoji: "❤️", verb: "Loved", removal: "a heart"},
+	{emoji: "👎", verb: "Disliked", removal: "a dislike"},
+	{emoji: "😂", verb: "Laughed at", removal: "a laugh"},
+	{emoji: "‼️", verb: "Emphasized", removal: "an exclamation"},
+	{emoji: "❓", verb: "Questioned", removal: "a question mark"},
+}
+
+const maxReactionExcerpt = 100 // runes of the original message quoted in an outbound reaction
+
+var (
+	tapbackAddedRE   = regexp.MustCompile(`(?s)^(Liked|Loved|Disliked|Laughed at|Emphasized|Questioned) (?:[“"](.*)[”"]|(an image))$`)
+	tapbackRemovedRE = regexp.MustCompile(`(?s)^Removed (a like|a heart|a dislike|a laugh|an exclamation|a question mark) from (?:[“"](.*)[”"]|(an image))$`)
+	emojiAddedRE     = regexp.MustCompile(`(?s)^Reacted (\S+) to (?:[“"](.*)[”"]|(an image))$`)
+	emojiRemovedRE   = regexp.MustCompile(`(?s)^Removed (\S+) from (?:[“"](.*)[”"]|(an image))$`)
+)
+
+// normalizeEmoji removes variation selectors, which clients add and remove inconsistently
+func normalizeEmoji(emoji string) string {
+	return strings.ReplaceAll(emoji, "\uFE0F", "")
+}
+
+func normalizeText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// parseTapback parses an inbound SMS containing a reaction.  It returns the
+// reaction's emoji, whether it was added or removed, the quoted text (or ""
+// if the reaction is to an image), and whether the SMS is a reaction at all.
+func parseTapback(body string) (emoji string, added bool, quote string, ok bool) {
+	if m := tapbackAddedRE.FindStringSubmatch(body); m != nil {
+		for _, tapback := range tapbacks {
+			if tapback.verb == m[1] {
+				return tapback.emoji, true, m[2], true
+			}
+		}
+	}
+	if m := tapbackRemovedRE.FindStringSubmatch(body); m != nil {
+		for _, tapback := range tapbacks {
+			if tapback.removal == m[1] {
+				return tapback.emoji, false, m[2], true
+			}
+		}
+	}
+	if m := emojiAddedRE.FindStringSubmatch(body); m != nil {
+		return m[1], true, m[2], true
+	}
+	if m := emojiRemovedRE.FindStringSubmatch(body); m != nil {
+		return m[1], false, m[2], true
+	}
+	return "", false, "", false
+}
+
+// matchesQuote reports whether the entry is the message quoted by a reaction.
+// Long messages are truncated with an ellipsis when quoted.
+func matchesQuote(entry *historyEntry, quote string) bool {
+	if quote == "" {
+		return entry.attachment
+	}
+	if entry.attachment {
+		return false
+	}
+	body, quote := normalizeText(entry.body), normalizeText(quote)
+	if prefix, truncated := strings.CutSuffix(quote, "…"); truncated {
+		return strings.HasPrefix(body, strings.TrimSpace(prefix))
+	}
+	return body == quote
+}
+
+// receiveTapback delivers an inbound SMS containing a reaction as an XEP-0444
+// reaction to the message it quotes.  It returns false if the SMS isn't a
+// reaction or the quoted message isn't in the history, in which case the SMS
+// should be delivered as text.
+func (service *Service) receiveTapback(from xmpp.Address, to xmpp.Address, body string) (bool, error) {
+	emoji, added, quote, ok := parseTapback(body)
+	if !ok {
+		return false, nil
+	}
+	conv, ok := service.makeConversation(to, from)
+	if !ok {
+		return false, nil
+	}
+	entry := service.history.find(conv, func(entry *historyEntry) bool { return matchesQuote(entry, quote) })
+	if entry == nil {
+		return false, nil
+	}
+
+	var targetID string
+	var reactions []string
+	service.history.update(func() {
+		entry.contactReactions = updateReactions(entry.contactReactions, emoji, added)
+		targetID = entry.id
+		reactions = slices.Clone(entry.contactReactions)
+	})
+
+	xmppMessage := messageStanza{
+		Header: xmpp.Header{
+			From: &from,
+			To:   &to,
+			ID:   xmpp.RandomID(),
+		},
+		Type:      xmpp.CHAT,
+		Body:      body,
+		Reactions: &reactionsElement{ID: targetID, Reactions: reactions},
+		Fallback:  []fallbackElement{{For: reactionsNS}},
+	}
+	if !service.sendWithin(5*time.Second, xmppMessage) {
+		return true, errors.New("Timed out when sending XMPP reaction")
+	}
+	return true, nil
+}
+
+func updateReactions(reactions []string, emoji string, added bool) []string {
+	reactions = slices.DeleteFunc(slices.Clone(reactions), func(reaction string) bool {
+		return normalizeEmoji(reaction) == normalizeEmoji(emoji)
+	})
+	if added {
+		reactions = append(reactions, emoji)
+	}
+	return reactions
+}
+
+// receiveXMPPReactions sends the reactions which the user added or removed
+// as tapback text which iMessage and Google Messages display as reactions
+func (service *Service) receiveXMPPReactions(xmppMessage *messageStanza) error {
+	user, userExists := service.lookupUser(*xmppMessage.From.Bare())
+	if !userExists {
+		return nil
+	}
+	toPhoneNumber, err := service.canonPhoneNumber(xmppMessage.To.LocalPart)
+	if err != nil {
+		return service.sendXMPPError(xmppMessage.To, xmppMessage.From, "Invalid phone number: "+err.Error())
+	}
+	conv := conversation{user: *xmppMessage.From.Bare(), contact: toPhoneNumber}
+	entry := service.history.findByID(conv, xmppMessage.Reactions.ID)
+	if entry == nil {
+		return service.sendXMPPError(xmppMessage.To, xmppMessage.From, "Your reaction could not be sent because the message you reacted to is too old")
+	}
+
+	var texts []string
+	service.history.update(func() {
+		excerpt := "an image"
+		if !entry.attachment {
+			excerpt = `"` + truncateText(normalizeText(entry.body), maxReactionExcerpt) + `"`
+		}
+		for _, emoji := range xmppMessage.Reactions.Reactions {
+			if !containsEmoji(entry.userReactions, emoji) {
+				texts = append(texts, tapbackText(emoji, true, excerpt))
+			}
+		}
+		for _, emoji := range entry.userReactions {
+			if !containsEmoji(xmppMessage.Reactions.Reactions, emoji) {
+				texts = append(texts, tapbackText(emoji, false, excerpt))
+			}
+		}
+		entry.userReactions = slices.Clone(xmppMessage.Reactions.Reactions)
+	})
+
+	// Tapbacks are queued and sent like any other outbound message, each
+	// with its own ID since they don't correspond to an XMPP message
+	type queuedTapback struct {
+		key     outboxKey
+		message *Message
+	}
+	var queued []queuedTapback
+	for _, text := range texts {
+		message := &Message{From: user.phoneNumber, To: toPhoneNumber, Body: text}
+		if !service.prepareText(xmppMessage.To, xmppMessage.From, message) {
+			continue
+		}
+		key := outboxKey{conversation: conv, id: xmpp.RandomID()}
+		service.recordOutbound(key, message)
+		service.queueOutbound(key, message, false)
+		queued = append(queued, queuedTapback{key: key, message: message})
+	}
+
+	sendDelay := service.getSendDelay()
+	go func() {
+		time.Sleep(sendDelay)
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+		for _, tapback := range queued {
+			if err := service.sendQueued(ctx, user, tapback.key, tapback.message); err != nil {
+				service.sendXMPPError(xmppMessage.To, xmppMessage.From, err.Error())
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func containsEmoji(reactions []string, emoji string) bool {
+	return slices.ContainsFunc(reactions, func(reaction string) bool {
+		return normalizeEmoji(reaction) == normalizeEmoji(emoji)
+	})
+}
+
+func tapbackText(emoji string, added bool, excerpt string) string {
+	for _, tapback := range tapbacks {
+		if normalizeEmoji(tapback.emoji) != normalizeEmoji(emoji) {
+			continue
+		}
+		if added {
+			return tapback.verb + " " + excerpt
+		}
+		return "Removed " + tapback.removal + " from " + excerpt
+	}
+	if added {
+		return "Reacted " + emoji + " to " + excerpt
+	}
+	return "Removed " + emoji + " from " + excerpt
+}
+
+func truncateText(text string, maxRunes int) string {
+	runes := []rune(text)
+	if len(runes) <= maxRunes {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:maxRunes-1])) + "…"
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"testing"
+)
+
+func TestParseTapback(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		emoji string
+		added bool
+		quote string
+		ok    bool
+	}{
+		{"liked", `Liked "see you at 5"`, "👍", true, "see you at 5", true},
+		{"loved with smart quotes", "Loved “see you at 5”", "❤️", true, "see you at 5", true},
+		{"laughed at", `Laughed at "that's hilarious"`, "😂", true, "that's hilarious", true},
+		{"emphasized", `Emphasized "don't forget"`, "‼️", true, "don't forget", true},
+		{"questioned", `Questioned "tomorrow?"`, "❓", true, "tomorrow?", true},
+		{"disliked image", "Disliked an image", "👎", true, "", true},
+		{"removed like", `Removed a like from "see you at 5"`, "👍", false, "see you at 5", true},
+		{"removed exclamation from image", "Removed an exclamation from an image", "‼️", false, "", true},
+		{"multi-line quote", "Liked “line one\nline two”", "👍", true, "line one\nline two", true},
+		{"quote containing quotes", `Liked "she said "hi""`, "👍", true, `she said "hi"`, true},
+		{"truncated quote", "Liked “a very long message…”", "👍", true, "a very long message…", true},
+		{"Google Messages emoji", "Reacted 🎉 to “we won”", "🎉", true, "we won", true},
+		{"Google Messages emoji removed", "Removed 🎉 from “we won”", "🎉", false, "we won", true},
+		{"Google Messages emoji to image", "Reacted 😮 to an image", "😮", true, "", true},
+		{"plain text", "see you at 5", "", false, "", false},
+		{"unknown verb", `Hated "see you at 5"`, "", false, "", false},
+		{"unquoted", "Liked see you at 5", "", false, "", false},
+		{"text after quote", `Liked "see you at 5" a lot`, "", false, "", false},
+		{"unknown removal", `Removed a hug from "see you at 5"`, "", false, "", false},
+		{"empty", "", "", false, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			emoji, added, quote, ok := parseTapback(test.body)
+			if emoji != test.emoji || added != test.added || quote != test.quote || ok != test.ok {
+				t.Errorf("parseTapback(%q) = (%q, %v, %q, %v), want (%q, %v, %q, %v)", test.body, emoji, added, quote, ok, test.emoji, test.added, test.quote, test.ok)
+			}
+		})
+	}
+}
+
+func TestMatchesQuote(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry historyEntry
+		quote string
+		want  bool
+	}{
+		{"exact", historyEntry{body: "see you at 5"}, "see you at 5", true},
+		{"whitespace differs", historyEntry{body: "see  you\nat 5"}, "see you at 5", true},
+		{"different text", historyEntry{body: "see you at 6"}, "see you at 5", false},
+		{"truncated", historyEntry{body: "a very long message indeed"}, "a very long message…", true},
+		{"truncated mismatch", historyEntry{body: "a short message"}, "a very long message…", false},
+		{"image", historyEntry{body: "https://example.com/a.jpg", attachment: true}, "", true},
+		{"image quote of text", historyEntry{body: "see you at 5"}, "", false},
+		{"text quote of image", historyEntry{body: "see you at 5", attachment: true}, "see you at 5", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := matchesQuote(&test.entry, test.quote); got != test.want {
+				t.Errorf("matchesQuote(%q, %q) = %v, want %v", test.entry.body, test.quote, got, test.want)
+			}
+		})
+	}
+}
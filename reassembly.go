@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reassembler combines the parts of a concatenated inbound SMS, for providers
+// which deliver each part separately.  Parts may arrive in any order.  Once
+// every part has arrived, the combined message is passed to Service.Receive.
+// If some parts haven't arrived before the timeout, the message is delivered
+// with the missing parts replaced by an ellipsis.
+type Reassembler struct {
+	deliver func(*Message) error
+	timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[reassemblyKey]*partialMessage
+}
+
+// MaxConcatParts is the largest number of parts a concatenated SMS can have,
+// since the part numbers in the user data header are a single byte
+const MaxConcatParts = 255
+
+// ErrInvalidConcatPart is returned by Reassembler.Receive if the part number
+// or number of parts is out of range.  Providers should respond with a
+// client error, since redelivering the part will not help.
+var ErrInvalidConcatPart = errors.New("invalid concatenated SMS part number")
+
+type reassemblyKey struct {
+	from string
+	to   string
+	ref  string
+}
+
+type partialMessage struct {
+	message  Message // the first part to arrive, minus its body
+	parts    []string
+	received []bool
+	count    int
+	timer    *time.Timer
+}
+
+func NewReassembler(service *Service, timeout time.Duration) *Reassembler {
+	return newReassembler(service.Receive, timeout)
+}
+
+func newReassembler(deliver func(*Message) error, timeout time.Duration) *Reassembler {
+	return &Reassembler{
+		deliver: deliver,
+		timeout: timeout,
+		pending: make(map[reassemblyKey]*partialMessage),
+	}
+}
+
+// Receive accepts part number part (starting from 1) of a message consisting
+// of total parts.  ref identifies the message among others from the same
+// sender.  An error wrapping ErrInvalidConcatPart is returned if part or total
+// is out of range.  Otherwise, an error is returned only if the message was
+// complete and Service.Receive failed, in which case the provider should ask
+// for the part to be redelivered.
+func (reassembler *Reassembler) Receive(message *Message, ref string, part int, total int) error {
+	if total <= 1 {
+		return reassembler.deliver(message)
+	}
+	if total > MaxConcatParts || part < 1 || part > total {
+		return fmt.Errorf("%w: concatenated SMS from %s has part number %d of %d", ErrInvalidConcatPart, message.From, part, total)
+	}
+	key := reassemblyKey{from: message.From, to: message.To, ref: ref}
+
+	reassembler.mu.Lock()
+	partial := reassembler.pending[key]
+	if partial == nil || len(partial.parts) != total {
+		if partial != nil {
+			partial.timer.Stop()
+		}
+		partial = &partialMessage{
+			message:  *message,
+			parts:    make([]string, total),
+			received: make([]bool, total),
+		}
+		partial.message.Body = ""
+		partial.timer = reassembler.startTimer(key, partial)
+		reassembler.pending[key] = partial
+	}
+	partial.parts[part-1] = message.Body
+	if !partial.received[part-1] {
+		partial.received[part-1] = true
+		partial.count++
+	}
+	if partial.count < total {
+		reassembler.mu.Unlock()
+		return nil
+	}
+	partial.timer.Stop()
+	delete(reassembler.pending, key)
+	reassembler.mu.Unlock()
+
+	if err := reassembler.deliver(partial.combine()); err != nil {
+		// Keep the parts so the message can be completed when this part is redelivered
+		reassembler.mu.Lock()
+		if _, exists := reassembler.pending[key]; !exists {
+			partial.timer = reassembler.startTimer(key, partial)
+			reassembler.pending[key] = partial
+		}
+		reassembler.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (reassembler *Reassembler) startTimer(key reassemblyKey, partial *partialMessage) *time.Timer {
+	return time.AfterFunc(reassembler.timeout, func() { reassembler.expire(key, partial) })
+}
+
+func (reassembler *Reassembler) expire(key reassemblyKey, partial *partialMessage) {
+	reassembler.mu.Lock()
+	if reassembler.pending[key] != partial {
+		reassembler.mu.Unlock()
+		return
+	}
+	delete(reassembler.pending, key)
+	reassembler.mu.Unlock()
+
+	log.Printf("Only received %d of %d parts of concatenated SMS from %s to %s; delivering incomplete message", partial.count, len(partial.parts), key.from, key.to)
+	if err := reassembler.deliver(partial.combine()); err != nil {
+		log.Printf("Unable to deliver incomplete concatenated SMS from %s to %s: %s", key.from, key.to, err)
+	}
+}
+
+func (partial *partialMessage) combine() *Message {
+	var body strings.Builder
+	for i, part := range partial.parts {
+		if partial.received[i] {
+			body.WriteString(part)
+		} else {
+			body.WriteString("…")
+		}
+	}
+	message := partial.message
+	message.Body = body.String()
+	return &message
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type deliveries struct {
+	mu       sync.Mutex
+	messages []Message
+	err      error // returned by deliver
+	done     chan struct{}
+}
+
+func newDeliveries() *deliveries {
+	return &deliveries{done: make(chan struct{}, 16)}
+}
+
+func (d *deliveries) deliver(message *Message) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return d.err
+	}
+	d.messages = append(d.messages, *message)
+	d.done <- struct{}{}
+	return nil
+}
+
+func (d *deliveries) bodies() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var bodies []string
+	for _, message := range d.messages {
+		bodies = append(bodies, message.Body)
+	}
+	return bodies
+}
+
+type part struct {
+	from  string
+	ref   string
+	part  int
+	total int
+	body  string
+}
+
+func TestReassembler(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts []part
+		want  []string
+	}{
+		{
+			name:  "unconcatenated",
+			parts: []part{{"+1", "", 1, 1, "hello"}},
+			want:  []string{"hello"},
+		},
+		{
+			name:  "in order",
+			parts: []part{{"+1", "a", 1, 3, "one "}, {"+1", "a", 2, 3, "two "}, {"+1", "a", 3, 3, "three"}},
+			want:  []string{"one two three"},
+		},
+		{
+			name:  "out of order",
+			parts: []part{{"+1", "a", 3, 3, "three"}, {"+1", "a", 1, 3, "one "}, {"+1", "a", 2, 3, "two "}},
+			want:  []string{"one two three"},
+		},
+		{
+			name:  "duplicate part",
+			parts: []part{{"+1", "a", 1, 2, "one "}, {"+1", "a", 1, 2, "one "}, {"+1", "a", 2, 2, "two"}},
+			want:  []string{"one two"},
+		},
+		{
+			name:  "interleaved messages",
+			parts: []part{{"+1", "a", 1, 2, "a1 "}, {"+1", "b", 1, 2, "b1 "}, {"+2", "a", 2, 2, "c2"}, {"+1", "b", 2, 2, "b2"}, {"+1", "a", 2, 2, "a2"}},
+			want:  []string{"b1 b2", "a1 a2"},
+		},
+		{
+			name:  "reference reused with different total",
+			parts: []part{{"+1", "a", 1, 3, "stale "}, {"+1", "a", 1, 2, "one "}, {"+1", "a", 2, 2, "two"}},
+			want:  []string{"one two"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newDeliveries()
+			reassembler := newReassembler(d.deliver, time.Hour)
+			for _, p := range test.parts {
+				if err := reassembler.Receive(&Message{From: p.from, To: "+2", Body: p.body}, p.ref, p.part, p.total); err != nil {
+					t.Fatalf("Receive(part %d of %d) failed: %s", p.part, p.total, err)
+				}
+			}
+			got := d.bodies()
+			if len(got) != len(test.want) {
+				t.Fatalf("delivered %q, want %q", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("delivered %q, want %q", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReassemblerInvalidPart(t *testing.T) {
+	tests := []struct {
+		part  int
+		total int
+	}{
+		{0, 2},
+		{-1, 2},
+		{3, 2},
+		{1, MaxConcatParts + 1},
+		{1, 1 << 30},
+	}
+	for _, test := range tests {
+		reassembler := newReassembler(newDeliveries().deliver, time.Hour)
+		err := reassembler.Receive(&Message{From: "+1", To: "+2"}, "a", test.part, test.total)
+		if !errors.Is(err, ErrInvalidConcatPart) {
+			t.Errorf("Receive(part %d of %d) returned %v, want ErrInvalidConcatPart", test.part, test.total, err)
+		}
+	}
+}
+
+func TestReassemblerExpiry(t *testing.T) {
+	d := newDeliveries()
+	reassembler := newReassembler(d.deliver, 10*time.Millisecond)
+	reassembler.Receive(&Message{From: "+1", To: "+2", Body: "one "}, "a", 1, 3)
+	reassembler.Receive(&Message{From: "+1", To: "+2", Body: "three"}, "a", 3, 3)
+	select {
+	case <-d.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("incomplete message was not delivered after the timeout")
+	}
+	if got, want := d.bodies(), "one …three"; len(got) != 1 || got[0] != want {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+
+	// A late part starts a new message rather than being combined with the expired one
+	reassembler.Receive(&Message{From: "+1", To: "+2", Body: "two "}, "a", 2, 3)
+	select {
+	case <-d.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("late part was not delivered after the timeout")
+	}
+	if got, want := d.bodies(), "…two …"; len(got) != 2 || got[1] != want {
+		t.Errorf("delivered %q, want second message %q", got, want)
+	}
+}
+
+func TestReassemblerRedelivery(t *testing.T) {
+	d := newDeliveries()
+	d.err = errors.New("XMPP server unavailable")
+	reassembler := newReassembler(d.deliver, time.Hour)
+	if err := reassembler.Receive(&Message{From: "+1", To: "+2", Body: "one "}, "a", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := reassembler.Receive(&Message{From: "+1", To: "+2", Body: "two"}, "a", 2, 2); err == nil {
+		t.Fatal("Receive succeeded even though delivery failed")
+	}
+
+	// The provider redelivers the last part, and the parts received earlier are kept
+	d.mu.Lock()
+	d.err = nil
+	d.mu.Unlock()
+	if err := reassembler.Receive(&Message{From: "+1", To: "+2", Body: "two"}, "a", 2, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := d.bodies(), "one two"; len(got) != 1 || got[0] != want {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+}
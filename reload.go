@@ -0,0 +1,235 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"src.agwa.name/go-xmpp"
+	"src.agwa.name/sms-over-xmpp/config"
+)
+
+// applyConfig validates the given configuration in its entirety and then
+// atomically replaces the service's users, providers, rosters, and admins.
+// If any part of the configuration is invalid, nothing is changed.
+func (service *Service) applyConfig(config *config.Config) error {
+	service.reloadMu.Lock()
+	defer service.reloadMu.Unlock()
+
+	if errs := CheckConfig(config); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	providers, err := service.makeProviders(config.Providers)
+	if err != nil {
+		return err
+	}
+	users, err := makeUsers(config.Users, providers)
+	if err != nil {
+		return err
+	}
+	rosterUsers, err := service.makeRosterUsers(config.Rosters, config.Users, config.SharedRoster, config.StateDir)
+	if err != nil {
+		return err
+	}
+	admins, err := makeAdmins(config.Admins)
+	if err != nil {
+		return err
+	}
+	rosterIntervals, err := makeRosterIntervals(config.RosterIntervals)
+	if err != nil {
+		return err
+	}
+	rosterPrefixes, err := makeRosterPrefixes(config.RosterPrefixes)
+	if err != nil {
+		return err
+	}
+	var mediaUploadService *xmpp.Address
+	if config.MediaUploadService != "" {
+		address, err := xmpp.ParseAddress(config.MediaUploadService)
+		if err != nil {
+			return fmt.Errorf("media_upload_service option is invalid: %s", err)
+		}
+		mediaUploadService = &address
+	}
+	httpHandler := service.makeHTTPHandler(providers, config.AdminHTTPPassword, config.MediaDir)
+
+	service.mu.Lock()
+	service.defaultPrefix = config.DefaultPrefix
+	service.publicURL = config.PublicURL
+	service.mediaUploadService = mediaUploadService
+	service.mediaDir = config.MediaDir
+	service.mediaRetention = time.Duration(config.MediaRetention) * 24 * time.Hour
+	service.stateDir = config.StateDir
+	service.sendDelay = time.Duration(config.SendDelay) * time.Second
+	service.sharedRosterURL = config.SharedRoster
+	service.rosterInterval = time.Duration(config.RosterInterval) * time.Second
+	service.rosterIntervals = rosterIntervals
+	service.rosterPrefixes = rosterPrefixes
+	service.combineMMS = config.CombineMMS
+	service.transliterate = config.Transliterate
+	service.segmentNotice = config.SegmentNotice
+	service.rosterOptions = rosterOptions{
+		defaultGroup: config.RosterDefaultGroup,
+		bookGroup:    config.RosterBookGroup,
+		allNumbers:   config.RosterAllNumbers,
+		twoWay:       config.RosterTwoWay,
+	}
+	service.users = users
+	service.rosterUsers = rosterUsers
+	service.providers = providers
+	service.providerConfigs = config.Providers
+	service.admins = admins
+	service.httpHandler = httpHandler
+	service.mu.Unlock()
+
+	select {
+	case service.rostersChanged <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// makeRosterUsers reuses the existing *rosterUser for users whose contact
+// source URL hasn't changed, so that their address book updater keeps running
+// undisturbed.  If there is a shared roster, every user gets a roster, even
+// if they don't have their own contact source.  New users get the push token
+// saved in their address book state, if any.
+func (service *Service) makeRosterUsers(rosters map[string]string, users map[string]config.UserConfig, sharedRoster string, stateDir string) (map[xmpp.Address]*rosterUser, error) {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+
+	sourceURLs := make(map[string]string)
+	if sharedRoster != "" {
+		for userJID := range users {
+			sourceURLs[userJID] = ""
+		}
+	}
+	for userJID, sourceURL := range rosters {
+		sourceURLs[userJID] = sourceURL
+	}
+
+	rosterUsers := make(map[xmpp.Address]*rosterUser)
+	for userJID, sourceURL := range sourceURLs {
+		userAddress, err := xmpp.ParseAddress(userJID)
+		if err != nil {
+			return nil, fmt.Errorf("User %s has malformed JID: %s", userJID, err)
+		}
+		if existing, exists := service.rosterUsers[userAddress]; exists && existing.sourceURL == sourceURL {
+			rosterUsers[userAddress] = existing
+			continue
+		}
+		pushToken := loadPushToken(addressBookStateFile(stateDir, userAddress), sourceURL)
+		if pushToken == "" {
+			pushToken = rand.Text()
+		}
+		rosterUsers[userAddress] = &rosterUser{
+			sourceURL: sourceURL,
+			pushToken: pushToken,
+			syncChan:  make(chan struct{}, 1),
+			edits:     make(chan rosterEdit, 16),
+		}
+	}
+	return rosterUsers, nil
+}
+
+func makeRosterIntervals(intervals map[string]int) (map[xmpp.Address]time.Duration, error) {
+	rosterIntervals := make(map[xmpp.Address]time.Duration)
+	for userJID, interval := range intervals {
+		userAddress, err := xmpp.ParseAddress(userJID)
+		if err != nil {
+			return nil, fmt.Errorf("User %s has malformed JID: %s", userJID, err)
+		}
+		rosterIntervals[userAddress] = time.Duration(interval) * time.Second
+	}
+	return rosterIntervals, nil
+}
+
+func makeRosterPrefixes(prefixes map[string]string) (map[xmpp.Address]string, error) {
+	rosterPrefixes := make(map[xmpp.Address]string)
+	for userJID, prefix := range prefixes {
+		userAddress, err := xmpp.ParseAddress(userJID)
+		if err != nil {
+			return nil, fmt.Errorf("User %s has malformed JID: %s", userJID, err)
+		}
+		rosterPrefixes[userAddress] = prefix
+	}
+	return rosterPrefixes, nil
+}
+
+func makeAdmins(adminJIDs []string) (map[xmpp.Address]bool, error) {
+	admins := make(map[xmpp.Address]bool)
+	for _, adminJID := range adminJIDs {
+		adminAddress, err := xmpp.ParseAddress(adminJID)
+		if err != nil {
+			return nil, fmt.Errorf("Admin %s has malformed JID: %s", adminJID, err)
+		}
+		admins[adminAddress] = true
+	}
+	return admins, nil
+}
+
+// Reload re-reads the configuration using the function passed to SetConfigLoader
+// and replaces the users, providers, rosters, and admins without interrupting the
+// XMPP connection or messages that are currently being sent.  If the new
+// configuration is invalid, an error is returned and the existing configuration
+// remains in effect.
+func (service *Service) Reload() error {
+	if service.loadConfig == nil {
+		return errors.New("reloading the configuration is not supported")
+	}
+	config, err := service.loadConfig()
+	if err != nil {
+		return err
+	}
+	if config.XMPPServer != service.xmppParams.Server || config.XMPPDomain != service.xmppParams.Domain || config.XMPPSecret != service.xmppParams.Secret {
+		log.Printf("Warning: changes to xmpp_server, xmpp_domain, and xmpp_secret will not take effect until sms-over-xmpp is restarted")
+	}
+	return service.applyConfig(config)
+}
+
+func (service *Service) handleReload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "405 Method Not Allowed: use POST to reload the configuration", 405)
+		return
+	}
+	if err := service.Reload(); err != nil {
+		log.Printf("Reloading configuration failed (keeping existing configuration): %s", err)
+		http.Error(w, "500 Internal Server Error: reloading configuration failed (keeping existing configuration): "+err.Error(), 500)
+		return
+	}
+	log.Printf("Reloaded configuration")
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(200)
+	fmt.Fprintln(w, "Configuration reloaded.")
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"strings"
+)
+
+const replyNS = "urn:xmpp:reply:0"
+
+// renderReply returns the text to send for the message.  SMS has no way to
+// refer to an earlier message, so a reply (XEP-0461) or a message beginning
+// with a quote block (XEP-0393) is sent with its quote replaced by an excerpt
+// no longer than quoteLength characters.  For replies, the excerpt is taken
+// from the original message if it is in the history, unless the client quoted
+// it without marking the quote as a fallback.  If quoteLength is 0, the quote
+// is omitted entirely.
+func (service *Service) renderReply(xmppMessage *messageStanza, quoteLength int) string {
+	var quote, body string
+	fallback := hasFallback(xmppMessage.Fallback, replyNS)
+	if fallback {
+		// Use the quote which the client included for clients that don't support replies
+		quote, _ = splitQuote(fallbackText(xmppMessage.Body, xmppMessage.Fallback, replyNS))
+		body = strings.TrimLeft(stripFallback(xmppMessage.Body, xmppMessage.Fallback, replyNS), "\n")
+	} else {
+		quote, body = splitQuote(xmppMessage.Body)
+	}
+	if body == "" {
+		// The quote is the whole message, so there is no text to keep separate
+		return xmppMessage.Body
+	}
+
+	if xmppMessage.Reply != nil && (fallback || quote == "") {
+		if conv, ok := service.makeConversation(*xmppMessage.From, *xmppMessage.To); ok {
+			if entry := service.history.findByID(conv, xmppMessage.Reply.ID); entry != nil && !entry.attachment {
+				quote = entry.body
+			}
+		}
+	}
+
+	quote = normalizeText(quote)
+	if quote == "" || quoteLength == 0 {
+		return body
+	}
+	return "> " + truncateText(quote, quoteLength) + "\n" + body
+}
+
+// hasFallback reports whether there is a fallback (XEP-0428) for the given namespace
+func hasFallback(fallbacks []fallbackElement, ns string) bool {
+	for _, fallback := range fallbacks {
+		if fallback.For == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// fallbackRanges returns the ranges of body, in runes, which are a fallback
+// (XEP-0428) for the given namespace.  A fallback without ranges covers the
+// whole body.
+func fallbackRanges(body []rune, fallbacks []fallbackElement, ns string) [][2]int {
+	var ranges [][2]int
+	for _, fallback := range fallbacks {
+		if fallback.For != ns {
+			continue
+		}
+		if len(fallback.Body) == 0 {
+			return [][2]int{{0, len(body)}}
+		}
+		for _, r := range fallback.Body {
+			if r.Start >= 0 && r.Start <= r.End && r.End <= len(body) {
+				ranges = append(ranges, [2]int{r.Start, r.End})
+			}
+		}
+	}
+	return ranges
+}
+
+// stripFallback removes the fallback text for the given namespace from body
+func stripFallback(body string, fallbacks []fallbackElement, ns string) string {
+	runes := []rune(body)
+	ranges := fallbackRanges(runes, fallbacks, ns)
+	if len(ranges) == 0 {
+		return body
+	}
+	isFallback := make([]bool, len(runes))
+	for _, r := range ranges {
+		for i := r[0]; i < r[1]; i++ {
+			isFallback[i] = true
+		}
+	}
+	var result strings.Builder
+	for i, r := range runes {
+		if !isFallback[i] {
+			result.WriteRune(r)
+		}
+	}
+	return result.String()
+}
+
+// fallbackText returns only the fallback text for the given namespace
+func fallbackText(body string, fallbacks []fallbackElement, ns string) string {
+	runes := []rune(body)
+	var result strings.Builder
+	for _, r := range fallbackRanges(runes, fallbacks, ns) {
+		result.WriteString(string(runes[r[0]:r[1]]))
+	}
+	return result.String()
+}
+
+// splitQuote splits body into the quote block (XEP-0393) at its beginning,
+// without the quote markers, and the rest of the body
+func splitQuote(body string) (string, string) {
+	lines := strings.Split(body, "\n")
+	var quoteLines []string
+	for len(lines) > 0 && strings.HasPrefix(lines[0], ">") {
+		quoteLines = append(quoteLines, strings.TrimPrefix(strings.TrimPrefix(lines[0], ">"), " "))
+		lines = lines[1:]
+	}
+	if len(quoteLines) == 0 {
+		return "", body
+	}
+	return strings.Join(quoteLines, "\n"), strings.TrimLeft(strings.Join(lines, "\n"), "\n")
+}
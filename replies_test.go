@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"testing"
+
+	"src.agwa.name/go-xmpp"
+)
+
+func TestSplitQuote(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		quote string
+		rest  string
+	}{
+		{"no quote", "hello", "", "hello"},
+		{"empty", "", "", ""},
+		{"quote and reply", "> see you at 5\nsounds good", "see you at 5", "sounds good"},
+		{"quote without space", ">see you at 5\nsounds good", "see you at 5", "sounds good"},
+		{"multi-line quote", "> line one\n> line two\nreply", "line one\nline two", "reply"},
+		{"blank line after quote", "> see you at 5\n\nsounds good", "see you at 5", "sounds good"},
+		{"only quote", "> see you at 5", "see you at 5", ""},
+		{"quote later in body", "sounds good\n> see you at 5", "", "sounds good\n> see you at 5"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			quote, rest := splitQuote(test.body)
+			if quote != test.quote || rest != test.rest {
+				t.Errorf("splitQuote(%q) = (%q, %q), want (%q, %q)", test.body, quote, rest, test.quote, test.rest)
+			}
+		})
+	}
+}
+
+func TestRenderReply(t *testing.T) {
+	from := xmpp.Address{LocalPart: "alice", DomainPart: "example.com"}
+	to := xmpp.Address{LocalPart: "+12125551212", DomainPart: "sms.example.com"}
+	quoteFallback := []fallbackElement{{For: replyNS, Body: []fallbackRange{{Start: 0, End: 15}}}}
+
+	tests := []struct {
+		name        string
+		body        string
+		reply       *replyElement
+		fallback    []fallbackElement
+		quoteLength int
+		want        string
+	}{
+		{"plain message", "hello", nil, nil, 50, "hello"},
+		{"quote typed by user", "> see you at 5\nsounds good", nil, nil, 50, "> see you at 5\nsounds good"},
+		{"long quote typed by user", "> see you at 5\nsounds good", nil, nil, 5, "> see…\nsounds good"},
+		{"multi-line quote typed by user", "> line one\n> line two\nreply", nil, nil, 50, "> line one line two\nreply"},
+		{"quote typed by user without quoting", "> see you at 5\nsounds good", nil, nil, 0, "sounds good"},
+		{"only quote typed by user", "> see you at 5", nil, nil, 0, "> see you at 5"},
+		{"reply", "> see you at 5\nsounds good", &replyElement{ID: "1"}, quoteFallback, 50, "> see you at 5\nsounds good"},
+		{"reply with truncated quote", "> see you at 5\nsounds good", &replyElement{ID: "1"}, quoteFallback, 5, "> see…\nsounds good"},
+		{"reply without quoting", "> see you at 5\nsounds good", &replyElement{ID: "1"}, quoteFallback, 0, "sounds good"},
+		{"reply without fallback", "sounds good", &replyElement{ID: "1"}, nil, 50, "sounds good"},
+		{"fallback without reply", "> see you at 5\nsounds good", nil, quoteFallback, 0, "sounds good"},
+		{"fallback covering whole body", "> see you at 5\nsounds good", &replyElement{ID: "1"}, []fallbackElement{{For: replyNS}}, 0, "> see you at 5\nsounds good"},
+		{"reply to message in history", "> see you at 5\nsounds good", &replyElement{ID: "h"}, quoteFallback, 50, "> see you at 5pm at the cafe\nsounds good"},
+		{"reply without fallback to message in history", "sounds good", &replyElement{ID: "h"}, nil, 50, "> see you at 5pm at the cafe\nsounds good"},
+		{"reply without fallback starting with quote", "> see you at 5\nsounds good", &replyElement{ID: "h"}, nil, 50, "> see you at 5\nsounds good"},
+		{"reply without fallback starting with long quote", "> see you at 5\nsounds good", &replyElement{ID: "h"}, nil, 5, "> see…\nsounds good"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := &Service{}
+			service.recordInbound(to, from, &historyEntry{id: "h", body: "see you at 5pm\nat the cafe"})
+			xmppMessage := &messageStanza{
+				Header:   xmpp.Header{From: &from, To: &to},
+				Body:     test.body,
+				Reply:    test.reply,
+				Fallback: test.fallback,
+			}
+			if got := service.renderReply(xmppMessage, test.quoteLength); got != test.want {
+				t.Errorf("renderReply(%q) = %q, want %q", test.body, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-vcard"
+	"github.com/emersion/go-webdav/carddav"
+	"src.agwa.name/go-xmpp"
+)
+
+var errVcardConflict = errors.New("the vCard was modified on the CardDAV server")
+
+// rosterEdit is a change made by the user to a contact in their XMPP roster,
+// to be written to their address book
+type rosterEdit struct {
+	jid  xmpp.Address
+	item RosterItem
+}
+
+// queueEdit passes the edit to the user's address book updater, if two-way
+// synchronization is enabled
+func (service *Service) queueEdit(user *rosterUser, edit rosterEdit) {
+	if !service.getRosterOptions().twoWay {
+		return
+	}
+	if edit.jid.DomainPart != service.xmppParams.Domain || edit.jid.LocalPart == "" {
+		return
+	}
+	select {
+	case user.edits <- edit:
+	default:
+		log.Printf("Dropping roster edit of %s because too many edits are pending", edit.jid)
+	}
+}
+
+// applyRosterEdit creates or updates the vCard of a contact which the user
+// added or changed in their roster.  If the vCard was modified on the CardDAV
+// server since it was downloaded, the address book is downloaded again and
+// the edit is retried once.
+func (service *Service) applyRosterEdit(ctx context.Context, client *carddav.Client, carddavURL string, addrbook *addressBook, edit rosterEdit, options rosterOptions) error {
+	number, err := service.canonPhoneNumber(edit.jid.LocalPart)
+	if err != nil {
+		return nil
+	}
+	for attempt := 0; ; attempt++ {
+		var path string
+		var card vcard.Card
+		var etag string
+		create := false
+		if object := addrbook.findNumber(number, options.defaultPrefix); object != nil {
+			expected := addrbook.makeRoster(service.xmppParams.Domain, options)[edit.jid]
+			card = cloneVcard(object.Card)
+			if !addrbook.updateVcard(card, edit.item, expected, options) {
+				return nil
+			}
+			path, etag = object.Path, object.ETag
+		} else {
+			path, card = newVcard(number, edit.item, addrbook.categories(edit.item.Groups, options))
+			create = true
+		}
+
+		err := putVcard(ctx, carddavURL, path, card, etag, create)
+		if errors.Is(err, errVcardConflict) && attempt == 0 {
+			if err := addrbook.download(ctx, client); err != nil {
+				return err
+			}
+			continue
+		}
+		return err
+	}
+}
+
+// findNumber returns the address book entry containing the phone number,
+// choosing the one with the lowest path like makeRoster, or nil if there is none
+func (addrbook *addressBook) findNumber(number string, defaultPrefix string) *carddav.AddressObject {
+	for _, path := range addrbook.sortedPaths() {
+		object := addrbook.entries[path]
+		for _, vcardNumber := range vcardNumbers(object.Card, defaultPrefix) {
+			if vcardNumber.number == number {
+				return &object
+			}
+		}
+	}
+	return nil
+}
+
+// updateVcard changes the vCard's name and categories to match the roster
+// item, given the roster item which makeRoster generated from the vCard.  It
+// returns false if nothing needs to change.
+func (addrbook *addressBook) updateVcard(card vcard.Card, item RosterItem, expected RosterItem, options rosterOptions) bool {
+	changed := false
+
+	oldName := card.PreferredValue(vcard.FieldFormattedName)
+	// makeRoster appends a label to the names of contacts with several numbers
+	newName := strings.TrimSuffix(item.Name, strings.TrimPrefix(expected.Name, oldName))
+	if item.Name != expected.Name && newName != "" && newName != oldName {
+		card.SetValue(vcard.FieldFormattedName, newName)
+		card.SetName(splitName(newName))
+		changed = true
+	}
+
+	if !slices.Equal(sortedCopy(item.Groups), expected.Groups) {
+		setVcardCategories(card, addrbook.categories(item.Groups, options))
+		changed = true
+	}
+	return changed
+}
+
+// categories returns the vCard categories corresponding to roster groups,
+// omitting the groups which makeRoster adds on its own
+func (addrbook *addressBook) categories(groups []string, options rosterOptions) []string {
+	var categories []string
+	for _, group := range groups {
+		if group == options.defaultGroup || (options.bookGroup && group == addrbook.name) {
+			continue
+		}
+		categories = append(categories, group)
+	}
+	slices.Sort(categories)
+	return categories
+}
+
+func newVcard(number string, item RosterItem, categories []string) (string, vcard.Card) {
+	uid := rand.Text()
+	name := item.Name
+	if name == "" {
+		name = formatPhoneNumber(number)
+	}
+	card := make(vcard.Card)
+	card.SetValue(vcard.FieldVersion, "3.0")
+	card.SetValue(vcard.FieldUID, uid)
+	card.SetValue(vcard.FieldFormattedName, name)
+	card.SetName(splitName(name))
+	card.Add(vcard.FieldTelephone, &vcard.Field{
+		Value:  number,
+		Params: vcard.Params{vcard.ParamType: {vcard.TypeCell}},
+	})
+	setVcardCategories(card, categories)
+	return uid + ".vcf", card
+}
+
+// setVcardCategories stores each category in its own CATEGORIES field, since
+// the vCard encoder escapes the commas which normally separate them
+func setVcardCategories(card vcard.Card, categories []string) {
+	delete(card, vcard.FieldCategories)
+	for _, category := range categories {
+		card.AddValue(vcard.FieldCategories, category)
+	}
+}
+
+// splitName guesses the structured name of a contact from their full name
+func splitName(name string) *vcard.Name {
+	words := strings.Fields(name)
+	if len(words) < 2 {
+		return &vcard.Name{GivenName: name}
+	}
+	return &vcard.Name{
+		GivenName:  strings.Join(words[:len(words)-1], " "),
+		FamilyName: words[len(words)-1],
+	}
+}
+
+func cloneVcard(card vcard.Card) vcard.Card {
+	clone := make(vcard.Card, len(card))
+	for name, fields := range card {
+		clone[name] = slices.Clone(fields)
+	}
+	return clone
+}
+
+func sortedCopy(s []string) []string {
+	s = slices.Clone(s)
+	slices.Sort(s)
+	return s
+}
+
+// putVcard uploads the vCard to path, which is resolved relative to the
+// address book URL.  If create is true, the upload fails if the path already
+// exists; otherwise it fails if the vCard's ETag no longer matches etag.
+// (carddav.Client.PutAddressObject doesn't support conditional requests.)
+func putVcard(ctx context.Context, carddavURL string, path string, card vcard.Card, etag string, create bool) error {
+	base, err := url.Parse(carddavURL)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	if err := vcard.NewEncoder(&body).Encode(card); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, base.ResolveReference(ref).String(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", vcard.MIMEType)
+	if create {
+		req.Header.Set("If-None-Match", "*")
+	} else if etag != "" {
+		req.Header.Set("If-Match", strconv.Quote(etag))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return errVcardConflict
+	}
+	if !(resp.StatusCode >= 200 && resp.StatusCode <= 299) {
+		return fmt.Errorf("HTTP error: %s", resp.Status)
+	}
+	return nil
+}
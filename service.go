@@ -32,18 +32,15 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"net/http"
 	"os"
-	"strings"
 	"sync"
 	"time"
 
-	"github.com/emersion/go-webdav/carddav"
-	"golang.org/x/sync/errgroup"
-
 	"src.agwa.name/go-xmpp"
-	"src.agwa.name/go-xmpp/component"
 	"src.agwa.name/sms-over-xmpp/config"
+	"src.agwa.name/sms-over-xmpp/httputil"
 )
 
 type RosterItem struct {
@@ -68,16 +65,34 @@ func (item RosterItem) Equal(other RosterItem) bool {
 
 type Roster map[xmpp.Address]RosterItem
 
+func (roster Roster) Equal(other Roster) bool {
+	if len(roster) != len(other) {
+		return false
+	}
+	for jid, item := range roster {
+		otherItem, exists := other[jid]
+		if !exists || !item.Equal(otherItem) {
+			return false
+		}
+	}
+	return true
+}
+
 var ErrRosterNotIntialized = errors.New("the roster for this user has not been initialized yet")
 
-const rosterSyncInterval = 15 * time.Second
+const maxRosterBackoff = time.Hour // longest time to wait before retrying a failed synchronization
 
 type rosterUser struct {
-	carddavURL string
+	sourceURL string // "" if the user only has the shared roster
+	pushToken string // identifies the user's WebDAV-Push resource
 
 	syncChan chan struct{}
+	edits    chan rosterEdit // changes made by the user to their roster, for two-way synchronization
 	rosterMu sync.Mutex
 	roster   Roster
+
+	contactsMu sync.Mutex
+	contacts   map[string]*contact // map from phone number -> address book entry
 }
 
 func (roster *rosterUser) forceSync() {
@@ -88,76 +103,214 @@ func (roster *rosterUser) forceSync() {
 }
 
 type user struct {
-	phoneNumber string // e.g. "+19255551212"
-	provider    Provider
+	phoneNumber      string // e.g. "+19255551212"
+	providerName     string
+	provider         Provider
+	replyQuoteLength int // characters of the original message to quote in replies, or 0
+}
+
+type providerStats struct {
+	sent   uint64
+	failed uint64
 }
 
 type Service struct {
-	defaultPrefix string // prepended to phone numbers that don't start with +
-	publicURL     string
-	users         map[xmpp.Address]user        // Map from bare JID -> user
-	rosterUsers   map[xmpp.Address]*rosterUser // Map from bare JID -> *rosterUser
-	providers     map[string]Provider
-	xmppParams    component.Params
-	xmppSendChan  chan interface{}
+	xmppParams     componentParams
+	xmppSendChan   chan interface{}
+	loadConfig     func() (*config.Config, error)
+	rostersChanged chan struct{}
+	reloadMu       sync.Mutex // serializes calls to applyConfig
+
+	mu                 sync.RWMutex // protects the following fields, which are replaced when the config is reloaded
+	defaultPrefix      string       // prepended to phone numbers that don't start with +
+	publicURL          string
+	mediaUploadService *xmpp.Address                    // XEP-0363 service to re-host inbound media on, or nil
+	mediaDir           string                           // directory to re-host inbound media in, or ""
+	mediaRetention     time.Duration                    // how long to keep re-hosted inbound media in mediaDir, or 0 for forever
+	combineMMS         bool                             // deliver inbound MMS as a single XMPP message
+	transliterate      bool                             // replace characters outside GSM-7 when that avoids UCS-2
+	segmentNotice      int                              // notify users of messages longer than this many segments, or 0
+	sendDelay          time.Duration                    // how long to hold outbound messages in the outbox before sending them
+	users              map[xmpp.Address]user            // Map from bare JID -> user
+	rosterUsers        map[xmpp.Address]*rosterUser     // Map from bare JID -> *rosterUser
+	providers          map[string]Provider              // Map from provider name -> Provider
+	providerConfigs    map[string]config.ProviderConfig // Map from provider name -> the configuration providers[name] was made from
+	admins             map[xmpp.Address]bool            // Set of bare JIDs allowed to run ad-hoc commands
+	httpHandler        http.Handler
+	rosterOptions      rosterOptions
+	stateDir           string
+	sharedRosterURL    string // contact source merged into every user's roster, or ""
+	rosterInterval     time.Duration
+	rosterIntervals    map[xmpp.Address]time.Duration // Map from bare JID -> interval, overriding rosterInterval
+	rosterPrefixes     map[xmpp.Address]string        // Map from bare JID -> prefix for national numbers in the address book, overriding defaultPrefix
+
+	sharedMu          sync.Mutex
+	sharedAddressBook *addressBook // the latest snapshot of the shared roster, or nil
+
+	pendingIqsMu sync.Mutex
+	pendingIqs   map[string]chan *iqStanza // Map from iq ID -> channel awaiting the response
+
+	segmentConfirmsMu sync.Mutex
+	segmentConfirms   map[segmentConfirmKey]segmentConfirm // Long messages awaiting confirmation by the user
+
+	outboxMu sync.Mutex
+	outbox   map[outboxKey]outboxEntry // Outbound messages which haven't been passed to the provider yet
+
+	history     messageHistory
+	callerNames callerNameCache
+
+	statsMu       sync.Mutex
+	providerStats map[string]*providerStats // Map from provider name -> *providerStats
 }
 
 func NewService(config *config.Config) (*Service, error) {
-	if config.DefaultPrefix != "" {
-		if err := validatePhoneNumber(config.DefaultPrefix); err != nil {
-			return nil, fmt.Errorf("default_prefix option is invalid: %s", err)
-		}
-	}
 	service := &Service{
-		defaultPrefix: config.DefaultPrefix,
-		publicURL:     config.PublicURL,
-		users:         make(map[xmpp.Address]user),
-		rosterUsers:   make(map[xmpp.Address]*rosterUser),
-		providers:     make(map[string]Provider),
-		xmppParams: component.Params{
+		xmppParams: componentParams{
 			Domain: config.XMPPDomain,
 			Secret: config.XMPPSecret,
 			Server: config.XMPPServer,
 			Logger: log.New(os.Stderr, "", log.Ldate|log.Ltime|log.Lmicroseconds),
 		},
-		xmppSendChan: make(chan interface{}),
+		xmppSendChan:    make(chan interface{}),
+		rostersChanged:  make(chan struct{}, 1),
+		pendingIqs:      make(map[string]chan *iqStanza),
+		outbox:          make(map[outboxKey]outboxEntry),
+		segmentConfirms: make(map[segmentConfirmKey]segmentConfirm),
+		providerStats:   make(map[string]*providerStats),
 	}
+	if err := service.applyConfig(config); err != nil {
+		return nil, err
+	}
+	return service, nil
+}
 
-	for providerName, providerConfig := range config.Providers {
+// makeProviders reuses the existing Provider for providers whose
+// configuration hasn't changed, so that their state (such as partially
+// received messages) survives a reload
+func (service *Service) makeProviders(configs map[string]config.ProviderConfig) (map[string]Provider, error) {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+
+	providers := make(map[string]Provider)
+	for providerName, providerConfig := range configs {
+		if existing, exists := service.providerConfigs[providerName]; exists && existing.Type == providerConfig.Type && maps.Equal(existing.Params, providerConfig.Params) {
+			providers[providerName] = service.providers[providerName]
+			continue
+		}
 		provider, err := MakeProvider(providerConfig.Type, service, providerConfig.Params)
 		if err != nil {
 			return nil, fmt.Errorf("Provider %s: %s", providerName, err)
 		}
-		service.providers[providerName] = provider
+		providers[providerName] = provider
 	}
+	return providers, nil
+}
 
-	for userJID, userConfig := range config.Users {
+func makeUsers(configs map[string]config.UserConfig, providers map[string]Provider) (map[xmpp.Address]user, error) {
+	users := make(map[xmpp.Address]user)
+	for userJID, userConfig := range configs {
 		userAddress, err := xmpp.ParseAddress(userJID)
 		if err != nil {
 			return nil, fmt.Errorf("User %s has malformed JID: %s", userJID, err)
 		}
-		userProvider, providerExists := service.providers[userConfig.Provider]
+		userProvider, providerExists := providers[userConfig.Provider]
 		if !providerExists {
 			return nil, fmt.Errorf("User %s refers to non-existent provider %s", userJID, userConfig.Provider)
 		}
-		service.users[userAddress] = user{
-			phoneNumber: userConfig.PhoneNumber,
-			provider:    userProvider,
+		users[userAddress] = user{
+			phoneNumber:      userConfig.PhoneNumber,
+			providerName:     userConfig.Provider,
+			provider:         userProvider,
+			replyQuoteLength: userConfig.ReplyQuoteLength,
 		}
 	}
+	return users, nil
+}
 
-	for userJID, carddavURL := range config.Rosters {
-		userAddress, err := xmpp.ParseAddress(userJID)
-		if err != nil {
-			return nil, fmt.Errorf("User %s has malformed JID: %s", userJID, err)
-		}
-		service.rosterUsers[userAddress] = &rosterUser{
-			carddavURL: carddavURL,
-			syncChan:   make(chan struct{}, 1),
+// SetConfigLoader specifies how the configuration is re-read by Reload.
+func (service *Service) SetConfigLoader(loadConfig func() (*config.Config, error)) {
+	service.loadConfig = loadConfig
+}
+
+func (service *Service) isAdmin(address xmpp.Address) bool {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	return service.admins[*address.Bare()]
+}
+
+func (service *Service) lookupUser(address xmpp.Address) (user, bool) {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	user, exists := service.users[address]
+	return user, exists
+}
+
+func (service *Service) addUser(address xmpp.Address, providerName string, phoneNumber string) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	provider, providerExists := service.providers[providerName]
+	if !providerExists {
+		return fmt.Errorf("provider %s does not exist", providerName)
+	}
+	if _, userExists := service.users[address]; userExists {
+		return fmt.Errorf("user %s already exists", address)
+	}
+	for otherAddress, otherUser := range service.users {
+		if otherUser.phoneNumber == phoneNumber {
+			return fmt.Errorf("phone number %s already belongs to %s", phoneNumber, otherAddress)
 		}
 	}
+	service.users[address] = user{
+		phoneNumber:      phoneNumber,
+		providerName:     providerName,
+		provider:         provider,
+		replyQuoteLength: config.DefaultReplyQuoteLength,
+	}
+	return nil
+}
 
-	return service, nil
+func (service *Service) lookupRosterUser(address xmpp.Address) (*rosterUser, bool) {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	user, exists := service.rosterUsers[address]
+	return user, exists
+}
+
+func (service *Service) removeUser(address xmpp.Address) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if _, userExists := service.users[address]; !userExists {
+		return fmt.Errorf("user %s does not exist", address)
+	}
+	delete(service.users, address)
+	return nil
+}
+
+func (service *Service) recordSend(providerName string, err error) {
+	service.statsMu.Lock()
+	defer service.statsMu.Unlock()
+
+	stats, exists := service.providerStats[providerName]
+	if !exists {
+		stats = new(providerStats)
+		service.providerStats[providerName] = stats
+	}
+	stats.sent++
+	if err != nil {
+		stats.failed++
+	}
+}
+
+func (service *Service) getProviderStats(providerName string) providerStats {
+	service.statsMu.Lock()
+	defer service.statsMu.Unlock()
+
+	if stats, exists := service.providerStats[providerName]; exists {
+		return *stats
+	}
+	return providerStats{}
 }
 
 func (service *Service) sendWithin(timeout time.Duration, stanza interface{}) bool {
@@ -180,66 +333,209 @@ func (service *Service) defaultHTTPHandler(w http.ResponseWriter, req *http.Requ
 	}
 }
 
-func (service *Service) HTTPHandler() http.Handler {
+func (service *Service) makeHTTPHandler(providers map[string]Provider, adminHTTPPassword string, mediaDir string) http.Handler {
 	mux := http.NewServeMux()
-	for name, provider := range service.providers {
+	for name, provider := range providers {
 		if providerHandler := provider.HTTPHandler(); providerHandler != nil {
 			mux.Handle("/"+name+"/", http.StripPrefix("/"+name, providerHandler))
 		}
 	}
+	if adminHTTPPassword != "" {
+		mux.Handle("/admin/reload", httputil.RequireHTTPAuthHandler(adminHTTPPassword, http.HandlerFunc(service.handleReload)))
+		mux.Handle("/admin/roster-sync", httputil.RequireHTTPAuthHandler(adminHTTPPassword, http.HandlerFunc(service.handleRosterSync)))
+	}
+	mux.HandleFunc("/roster-push/", service.handleRosterPush)
+	if mediaDir != "" {
+		mux.Handle("/media/", http.StripPrefix("/media", makeMediaHandler(mediaDir)))
+	}
 	mux.HandleFunc("/", service.defaultHTTPHandler)
 	return mux
 }
 
+func (service *Service) HTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		service.mu.RLock()
+		handler := service.httpHandler
+		service.mu.RUnlock()
+		handler.ServeHTTP(w, req)
+	})
+}
+
 func (service *Service) RunXMPPComponent(ctx context.Context) error {
-	callbacks := component.Callbacks{
+	callbacks := componentCallbacks{
 		Message:  service.receiveXMPPMessage,
 		Presence: service.receiveXMPPPresence,
 		Iq:       service.receiveXMPPIq,
 	}
 
-	return component.Run(ctx, service.xmppParams, callbacks, service.xmppSendChan)
+	return runComponent(ctx, service.xmppParams, callbacks, service.xmppSendChan)
 }
 
 func (service *Service) RunAddressBookUpdater(ctx context.Context) error {
-	group, ctx := errgroup.WithContext(ctx)
-	for userJID, user := range service.rosterUsers {
-		userJID, user := userJID, user
-		group.Go(func() error {
-			return service.runAddressBookUpdaterFor(ctx, userJID, user)
-		})
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	running := make(map[*rosterUser]context.CancelFunc)
+	var runningSharedURL string
+	cancelShared := context.CancelFunc(func() {})
+	for {
+		service.mu.RLock()
+		current := make(map[*rosterUser]xmpp.Address, len(service.rosterUsers))
+		for userJID, user := range service.rosterUsers {
+			current[user] = userJID
+		}
+		sharedURL := service.sharedRosterURL
+		service.mu.RUnlock()
+
+		if sharedURL != runningSharedURL {
+			cancelShared()
+			cancelShared = func() {}
+			runningSharedURL = sharedURL
+			service.setSharedAddressBook(nil)
+			if sharedURL != "" {
+				sharedCtx, cancel := context.WithCancel(ctx)
+				cancelShared = cancel
+				go service.runSharedAddressBookUpdater(sharedCtx, sharedURL)
+			}
+		}
+
+		for user, cancelUser := range running {
+			if _, exists := current[user]; !exists {
+				cancelUser()
+				delete(running, user)
+			}
+		}
+		for user, userJID := range current {
+			if _, exists := running[user]; exists {
+				continue
+			}
+			userCtx, cancelUser := context.WithCancel(ctx)
+			running[user] = cancelUser
+			go service.retryAddressBookUpdaterFor(userCtx, userJID, user)
+		}
+
+		select {
+		case <-service.rostersChanged:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// retryAddressBookUpdaterFor runs the user's address book updater until ctx
+// is canceled, restarting it with backoff if it fails, so that one user's
+// failure doesn't affect other users
+func (service *Service) retryAddressBookUpdaterFor(ctx context.Context, userJID xmpp.Address, user *rosterUser) {
+	for failures := 0; ; failures++ {
+		err := service.runAddressBookUpdaterFor(ctx, userJID, user)
+		if ctx.Err() != nil {
+			return
+		}
+		delay := service.rosterSyncDelay(userJID, failures, false)
+		log.Printf("Address book updater for %s failed (retrying in %s): %s", userJID, delay, err)
+		timeout := time.NewTimer(delay)
+		select {
+		case <-timeout.C:
+		case <-ctx.Done():
+			timeout.Stop()
+			return
+		}
 	}
-	return group.Wait()
 }
 
 func (service *Service) runAddressBookUpdaterFor(ctx context.Context, userJID xmpp.Address, user *rosterUser) error {
-	if err := service.sendXMPPRosterQuery(xmpp.RandomID(), userJID, "get", xmpp.RosterQuery{}); err != nil {
+	if err := service.sendXMPPRosterQuery(xmpp.RandomID(), userJID, "get", rosterQuery{}); err != nil {
 		return fmt.Errorf("unable to query roster for %s: %w", userJID, err)
 	}
-	client, err := carddav.NewClient(http.DefaultClient, user.carddavURL)
-	if err != nil {
-		return fmt.Errorf("unable to create CardDAV client for %s: %w", userJID, err)
+	var source contactSource
+	if user.sourceURL != "" {
+		var err error
+		source, err = newContactSource(user.sourceURL)
+		if err != nil {
+			return fmt.Errorf("roster for %s has invalid URL: %w", userJID, err)
+		}
 	}
+	statePath := service.addressBookStatePath(userJID)
 	addrbook := new(addressBook)
+	if statePath != "" {
+		if loaded, err := loadAddressBook(statePath, user.sourceURL, service.xmppParams.Domain); err == nil {
+			addrbook = loaded
+			// Start from the saved contacts, so that only avatars which have changed since are published
+			options := service.getRosterOptionsFor(userJID)
+			user.initContacts(addrbook.merge(service.getSharedAddressBook()).makeContacts(options.defaultPrefix))
+		} else {
+			log.Printf("Ignoring saved address book state for %s: %s", userJID, err)
+		}
+	}
+	var appliedOptions rosterOptions
+	var appliedShared *addressBook
+	reconciled := false // whether the roster has been diffed against the server's roster since startup
+	var push *pushSubscription
+	defer func() { push.unregister() }()
+	failures := 0
 	for {
-		if err := addrbook.download(ctx, client); err != nil {
-			log.Printf("Error downloading address book for %s: %s", userJID, err)
+		var syncErr error
+		if source != nil {
+			syncErr = source.sync(ctx, addrbook)
 		}
-		if addrbook.changed {
-			newRoster := addrbook.makeRoster(service.xmppParams.Domain)
-			log.Printf("%s: Setting roster = %#v", userJID, newRoster)
-			if err := service.setRoster(ctx, userJID, user, newRoster); err == nil {
+		if syncErr != nil {
+			failures++
+		} else {
+			failures = 0
+			if source, ok := source.(*carddavSource); ok {
+				push = service.renewPushSubscription(ctx, userJID, user, source, push)
+			}
+		}
+		options := service.getRosterOptionsFor(userJID)
+		shared := service.getSharedAddressBook()
+		if addrbook.changed || options != appliedOptions || shared != appliedShared {
+			merged := addrbook.merge(shared)
+			newContacts := merged.makeContacts(options.defaultPrefix)
+			oldContacts := user.setContacts(newContacts)
+			service.publishAvatarChanges(userJID, oldContacts, newContacts)
+			newRoster := merged.makeRoster(service.xmppParams.Domain, options)
+			if reconciled && newRoster.Equal(addrbook.roster) {
+				// The roster was already pushed.  The saved roster isn't trusted
+				// until after the first sync, since the user may have changed their
+				// roster while sms-over-xmpp wasn't running.
+				addrbook.changed = false
+				appliedOptions, appliedShared = options, shared
+			} else if err := service.setRoster(ctx, userJID, user, newRoster); err == nil {
+				log.Printf("%s: Set roster = %#v", userJID, newRoster)
 				addrbook.changed = false
+				addrbook.roster = newRoster
+				addrbook.dirty = true
+				appliedOptions, appliedShared = options, shared
+				reconciled = true
 			} else if err != ErrRosterNotIntialized {
 				log.Printf("Error setting roster for %s: %s", userJID, err)
 			}
 		}
+		if addrbook.dirty && statePath != "" {
+			if err := addrbook.save(statePath, user.sourceURL, user.pushToken); err == nil {
+				addrbook.dirty = false
+			} else {
+				log.Printf("Error saving address book state for %s: %s", userJID, err)
+			}
+		}
 
-		timeout := time.NewTimer(rosterSyncInterval)
+		delay := service.rosterSyncDelay(userJID, failures, push.active())
+		if syncErr != nil && ctx.Err() == nil {
+			log.Printf("Error downloading address book for %s (retrying in %s): %s", userJID, delay, syncErr)
+		}
+		timeout := time.NewTimer(delay)
 		select {
 		case <-timeout.C:
 		case <-user.syncChan:
 			timeout.Stop()
+		case edit := <-user.edits:
+			timeout.Stop()
+			// Only CardDAV address books can be written to
+			if source, ok := source.(*carddavSource); ok {
+				if err := service.applyRosterEdit(ctx, source.client, source.url, addrbook, edit, options); err != nil {
+					log.Printf("Error writing roster edit of %s to address book for %s: %s", edit.jid, userJID, err)
+				}
+			}
 		case <-ctx.Done():
 			timeout.Stop()
 			return ctx.Err()
@@ -247,8 +543,92 @@ func (service *Service) runAddressBookUpdaterFor(ctx context.Context, userJID xm
 	}
 }
 
+// runSharedAddressBookUpdater synchronizes the shared roster, which is merged
+// into every user's roster, and makes every user's updater rebuild their
+// roster when it changes
+func (service *Service) runSharedAddressBookUpdater(ctx context.Context, sourceURL string) {
+	source, err := newContactSource(sourceURL)
+	if err != nil {
+		log.Printf("Shared roster has invalid URL: %s", err)
+		return
+	}
+	addrbook := new(addressBook)
+	failures := 0
+	for {
+		syncErr := source.sync(ctx, addrbook)
+		if syncErr != nil {
+			failures++
+		} else {
+			failures = 0
+		}
+		if addrbook.changed && ctx.Err() == nil {
+			service.setSharedAddressBook(addrbook.snapshot())
+			addrbook.changed = false
+		}
+
+		delay := service.rosterSyncDelay(xmpp.Address{}, failures, false)
+		if syncErr != nil && ctx.Err() == nil {
+			log.Printf("Error downloading shared address book (retrying in %s): %s", delay, syncErr)
+		}
+		timeout := time.NewTimer(delay)
+		select {
+		case <-timeout.C:
+		case <-ctx.Done():
+			timeout.Stop()
+			return
+		}
+	}
+}
+
+// rosterSyncDelay returns how long to wait before synchronizing the user's
+// address book again.  The delay doubles with each consecutive failure, and
+// is longer while the server pushes changes, since polling is then only a
+// fallback in case a push is lost.
+func (service *Service) rosterSyncDelay(userJID xmpp.Address, failures int, pushing bool) time.Duration {
+	service.mu.RLock()
+	delay, exists := service.rosterIntervals[userJID]
+	if !exists {
+		delay = service.rosterInterval
+	}
+	service.mu.RUnlock()
+
+	if pushing {
+		delay = max(delay, rosterPushPollInterval)
+	}
+	for i := 0; i < failures && delay < maxRosterBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRosterBackoff)
+}
+
+func (service *Service) getPublicURL() string {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	return service.publicURL
+}
+
+func (service *Service) getSharedAddressBook() *addressBook {
+	service.sharedMu.Lock()
+	defer service.sharedMu.Unlock()
+	return service.sharedAddressBook
+}
+
+// setSharedAddressBook replaces the shared roster and makes every user's
+// address book updater merge it into their roster
+func (service *Service) setSharedAddressBook(addrbook *addressBook) {
+	service.sharedMu.Lock()
+	service.sharedAddressBook = addrbook
+	service.sharedMu.Unlock()
+
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	for _, user := range service.rosterUsers {
+		user.forceSync()
+	}
+}
+
 func (service *Service) Receive(message *Message) error {
-	address, known := service.addressForPhoneNumber(message.To)
+	address, user, known := service.userForPhoneNumber(message.To)
 	if !known {
 		return errors.New("Unknown phone number " + message.To)
 	}
@@ -257,49 +637,77 @@ func (service *Service) Receive(message *Message) error {
 		DomainPart: service.xmppParams.Domain,
 	}
 
-	if err := service.sendXMPPChat(from, address, message.Body); err != nil {
-		return err
-	}
+	service.waitForCallerName(address, user.provider, message.From)
 
-	for _, mediaURL := range message.MediaURLs {
-		if err := service.sendXMPPMediaURL(from, address, mediaURL); err != nil {
+	if len(message.MediaURLs) == 0 {
+		if isTapback, err := service.receiveTapback(from, address, message.Body); isTapback {
 			return err
 		}
+		return service.sendXMPPChat(from, address, message.Body)
 	}
 
-	return nil
+	body := message.Body
+	if !service.combineMMSEnabled() {
+		// Send the text right away, and the attachments as separate messages
+		if body != "" {
+			if err := service.sendXMPPChat(from, address, body); err != nil {
+				return err
+			}
+		}
+		body = ""
+	}
+
+	if service.mediaRehostingEnabled() {
+		// Re-hosting can take longer than providers are willing to wait for a webhook to respond
+		go service.receiveMedia(from, address, user.provider, body, message.MediaURLs)
+		return nil
+	}
+
+	attachments := make([]attachment, len(message.MediaURLs))
+	for i, mediaURL := range message.MediaURLs {
+		attachments[i] = attachment{url: mediaURL}
+	}
+	return service.sendXMPPMMS(from, address, body, attachments)
 }
 
 func (service *Service) sendXMPPChat(from xmpp.Address, to xmpp.Address, body string) error {
-	xmppMessage := xmpp.Message{
+	xmppMessage := messageStanza{
 		Header: xmpp.Header{
 			From: &from,
 			To:   &to,
+			ID:   xmpp.RandomID(),
 		},
-		Body: body,
-		Type: xmpp.CHAT,
+		Body:              body,
+		Type:              xmpp.CHAT,
+		chatStateElements: service.activeChatState(from, to),
+		Nick:              service.senderNick(from, to),
 	}
 
 	if !service.sendWithin(5*time.Second, xmppMessage) {
 		return errors.New("Timed out when sending XMPP message")
 	}
+	service.recordInbound(from, to, &historyEntry{id: xmppMessage.ID, body: body})
 	return nil
 }
 
 func (service *Service) sendXMPPMediaURL(from xmpp.Address, to xmpp.Address, mediaURL string) error {
-	xmppMessage := xmpp.Message{
+	xmppMessage := messageStanza{
 		Header: xmpp.Header{
 			From: &from,
 			To:   &to,
+			ID:   xmpp.RandomID(),
 		},
-		Body:          mediaURL,
-		Type:          xmpp.CHAT,
-		OutOfBandData: &xmpp.OutOfBandData{URL: mediaURL},
+		Body:              mediaURL,
+		Type:              xmpp.CHAT,
+		OutOfBandData:     []outOfBandData{{URL: mediaURL}},
+		chatStateElements: service.activeChatState(from, to),
+		Nick:              service.senderNick(from, to),
 	}
 
 	if !service.sendWithin(5*time.Second, xmppMessage) {
 		return errors.New("Timed out when sending XMPP message with out-of-band data")
 	}
+	service.recordInbound(from, to, &historyEntry{id: xmppMessage.ID, body: mediaURL, attachment: true})
 	return nil
 }
 
@@ -307,23 +715,28 @@ func shouldForwardMessageType(t xmpp.MessageType) bool {
 	return t == "" || t == xmpp.CHAT || t == xmpp.NORMAL
 }
 
-func messageHasContent(message *xmpp.Message) bool {
-	// This function filters out "$user is typing" messages
-	return message.Body != "" || message.OutOfBandData != nil
-}
-
-func shouldForwardMessage(message *xmpp.Message) bool {
-	return shouldForwardMessageType(message.Type) && messageHasContent(message)
+func messageHasContent(message *messageStanza) bool {
+	// This function distinguishes messages from standalone "$user is typing" notifications
+	return message.Body != "" || len(xmppMessageAttachments(message)) > 0
 }
 
-func (service *Service) receiveXMPPMessage(ctx context.Context, xmppMessage *xmpp.Message) error {
+func (service *Service) receiveXMPPMessage(ctx context.Context, xmppMessage *messageStanza) error {
 	if xmppMessage.From == nil || xmppMessage.To == nil {
 		return errors.New("Received malformed XMPP message: From and To not set")
 	}
-	if !shouldForwardMessage(xmppMessage) {
+	if !shouldForwardMessageType(xmppMessage.Type) {
 		return nil
 	}
-	user, userExists := service.users[*xmppMessage.From.Bare()]
+	if xmppMessage.Retract != nil {
+		return service.receiveXMPPRetraction(xmppMessage)
+	}
+	if xmppMessage.Reactions != nil {
+		return service.receiveXMPPReactions(xmppMessage)
+	}
+	if !messageHasContent(xmppMessage) {
+		return service.receiveXMPPChatState(xmppMessage)
+	}
+	user, userExists := service.lookupUser(*xmppMessage.From.Bare())
 	if !userExists {
 		return service.sendXMPPError(xmppMessage.To, xmppMessage.From, xmppMessage.From.Bare().String()+" is not a known user; please add them to sms-over-xmpp's users file")
 	}
@@ -337,31 +750,46 @@ func (service *Service) receiveXMPPMessage(ctx context.Context, xmppMessage *xmp
 		From: user.phoneNumber,
 		To:   toPhoneNumber,
 	}
-	if xmppMessage.OutOfBandData != nil {
-		message.MediaURLs = append(message.MediaURLs, xmppMessage.OutOfBandData.URL)
+	message.MediaURLs = xmppMessageAttachments(xmppMessage)
+	message.Body = stripAttachmentURLs(service.renderReply(xmppMessage, user.replyQuoteLength), message.MediaURLs)
+
+	if !service.prepareText(xmppMessage.To, xmppMessage.From, message) {
+		return nil
+	}
+
+	key := outboxKey{conversation: conversation{user: *xmppMessage.From.Bare(), contact: toPhoneNumber}, id: xmppMessage.ID}
+	if xmppMessage.Replace != nil {
+		key.id = xmppMessage.Replace.ID // corrections always refer to the ID of the original message
+		if !service.replaceQueued(key, message) {
+			// The history keeps the text of the original message, which is what the contact has
+			message.Body = correctionPrefix + message.Body
+			service.queueOutbound(key, message, true)
+		}
 	} else {
-		message.Body = xmppMessage.Body
+		service.recordOutbound(key, message)
+		service.queueOutbound(key, message, false)
 	}
 
+	sendDelay := service.getSendDelay()
 	go func() {
+		time.Sleep(sendDelay)
 		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 		defer cancel()
-		err := user.provider.Send(ctx, message)
-		if err != nil {
+		if err := service.sendQueued(ctx, user, key, message); err != nil {
 			// TODO: if sendXMPPError fails, log the error
-			service.sendXMPPError(xmppMessage.To, xmppMessage.From, "Sending SMS failed: "+err.Error())
+			service.sendXMPPError(xmppMessage.To, xmppMessage.From, err.Error())
 		}
 	}()
 
 	return nil
 }
 
-func (service *Service) receiveXMPPPresence(ctx context.Context, presence *xmpp.Presence) error {
+func (service *Service) receiveXMPPPresence(ctx context.Context, presence *presenceStanza) error {
 	if presence.From == nil || presence.To == nil {
 		return errors.New("Received malformed XMPP presence: From and To not set")
 	}
 
-	if _, userExists := service.users[*presence.From.Bare()]; !userExists {
+	if _, userExists := service.lookupUser(*presence.From.Bare()); !userExists {
 		return nil
 	}
 
@@ -378,6 +806,11 @@ func (service *Service) receiveXMPPPresence(ctx context.Context, presence *xmpp.
 		if _, err := service.canonPhoneNumber(presence.To.LocalPart); err != nil {
 			presenceType = "error"
 			status = "Invalid phone number: " + err.Error()
+		} else if presence.To.LocalPart != "" {
+			if !service.sendWithin(5*time.Second, service.contactPresence(*presence.To, *presence.From)) {
+				return errors.New("Timed out when sending XMPP presence")
+			}
+			return nil
 		}
 
 		if err := service.sendXMPPPresence(presence.To, presence.From, presenceType, status); err != nil {
@@ -388,21 +821,34 @@ func (service *Service) receiveXMPPPresence(ctx context.Context, presence *xmpp.
 	return nil
 }
 
-func (service *Service) receiveXMPPIq(ctx context.Context, iq *xmpp.Iq) error {
+func (service *Service) receiveXMPPIq(ctx context.Context, iq *iqStanza) error {
+	if (iq.Type == "result" || iq.Type == "error") && service.deliverIqResponse(iq) {
+		return nil
+	}
 	switch {
 	case iq.RosterQuery != nil:
 		return service.receiveXMPPRosterQuery(ctx, iq)
+	case iq.DiscoInfo != nil:
+		return service.receiveXMPPDiscoInfo(ctx, iq)
+	case iq.DiscoItems != nil:
+		return service.receiveXMPPDiscoItems(ctx, iq)
+	case iq.Command != nil:
+		return service.receiveXMPPCommand(ctx, iq)
+	case iq.VCard != nil || iq.VCard4 != nil:
+		return service.receiveXMPPVCard(ctx, iq)
+	case iq.PubSub != nil:
+		return service.receiveXMPPPubSub(ctx, iq)
 	default:
 		return nil
 	}
 }
 
-func (service *Service) receiveXMPPRosterQuery(ctx context.Context, iq *xmpp.Iq) error {
+func (service *Service) receiveXMPPRosterQuery(ctx context.Context, iq *iqStanza) error {
 	if iq.From == nil || iq.To == nil {
 		return errors.New("Received malformed XMPP iq: From and To not set")
 	}
 
-	user, userExists := service.rosterUsers[*iq.From.Bare()]
+	user, userExists := service.lookupRosterUser(*iq.From.Bare())
 	if !userExists {
 		return nil
 	}
@@ -417,7 +863,7 @@ func (service *Service) receiveXMPPRosterQuery(ctx context.Context, iq *xmpp.Iq)
 	}
 }
 
-func (service *Service) receiveXMPPRosterSet(ctx context.Context, user *rosterUser, query *xmpp.RosterQuery) error {
+func (service *Service) receiveXMPPRosterSet(ctx context.Context, user *rosterUser, query *rosterQuery) error {
 	user.rosterMu.Lock()
 	defer user.rosterMu.Unlock()
 
@@ -431,16 +877,20 @@ func (service *Service) receiveXMPPRosterSet(ctx context.Context, user *rosterUs
 	if item.Subscription == "remove" {
 		delete(user.roster, item.JID)
 	} else {
-		user.roster[item.JID] = RosterItem{
+		newItem := RosterItem{
 			Name:   item.Name,
-			Groups: item.Groups,
+			Groups: sortedCopy(item.Groups),
 		}
+		if curItem, exists := user.roster[item.JID]; !exists || !curItem.Equal(newItem) {
+			service.queueEdit(user, rosterEdit{jid: item.JID, item: newItem})
+		}
+		user.roster[item.JID] = newItem
 	}
 
 	return nil
 }
 
-func (service *Service) receiveXMPPRosterResult(ctx context.Context, user *rosterUser, query *xmpp.RosterQuery) error {
+func (service *Service) receiveXMPPRosterResult(ctx context.Context, user *rosterUser, query *rosterQuery) error {
 	user.rosterMu.Lock()
 	defer user.rosterMu.Unlock()
 
@@ -458,20 +908,20 @@ func (service *Service) receiveXMPPRosterResult(ctx context.Context, user *roste
 	return nil
 }
 
-func replaceRoster(user *rosterUser, newRoster Roster) ([]xmpp.RosterItem, error) {
+func replaceRoster(user *rosterUser, newRoster Roster) ([]rosterItem, error) {
 	user.rosterMu.Lock()
 	defer user.rosterMu.Unlock()
 	if user.roster == nil {
 		return nil, ErrRosterNotIntialized
 	}
 
-	changes := []xmpp.RosterItem{}
+	changes := []rosterItem{}
 
 	for jid, newItem := range newRoster {
 		curItem, exists := user.roster[jid]
 		if !exists || !curItem.Equal(newItem) {
 			user.roster[jid] = newItem
-			changes = append(changes, xmpp.RosterItem{
+			changes = append(changes, rosterItem{
 				JID:          jid,
 				Name:         newItem.Name,
 				Subscription: "both",
@@ -483,7 +933,7 @@ func replaceRoster(user *rosterUser, newRoster Roster) ([]xmpp.RosterItem, error
 		_, exists := newRoster[jid]
 		if !exists {
 			delete(user.roster, jid)
-			changes = append(changes, xmpp.RosterItem{
+			changes = append(changes, rosterItem{
 				JID:          jid,
 				Subscription: "remove",
 			})
@@ -494,7 +944,7 @@ func replaceRoster(user *rosterUser, newRoster Roster) ([]xmpp.RosterItem, error
 }
 
 func (service *Service) SetRoster(ctx context.Context, userJID xmpp.Address, newRoster Roster) error {
-	user, userExists := service.rosterUsers[userJID]
+	user, userExists := service.lookupRosterUser(userJID)
 	if !userExists {
 		return errors.New("no such user")
 	}
@@ -508,8 +958,8 @@ func (service *Service) setRoster(ctx context.Context, userJID xmpp.Address, use
 	}
 
 	for _, changedItem := range changes {
-		query := xmpp.RosterQuery{
-			Items: []xmpp.RosterItem{changedItem},
+		query := rosterQuery{
+			Items: []rosterItem{changedItem},
 		}
 		if err := service.sendXMPPRosterQuery(xmpp.RandomID(), userJID, "set", query); err != nil {
 			return err
@@ -518,8 +968,8 @@ func (service *Service) setRoster(ctx context.Context, userJID xmpp.Address, use
 	return nil
 }
 
-func (service *Service) sendXMPPRosterQuery(id string, to xmpp.Address, iqType string, query xmpp.RosterQuery) error {
-	iq := &xmpp.Iq{
+func (service *Service) sendXMPPRosterQuery(id string, to xmpp.Address, iqType string, query rosterQuery) error {
+	iq := &iqStanza{
 		Header: xmpp.Header{
 			From: &xmpp.Address{DomainPart: service.xmppParams.Domain},
 			ID:   id,
@@ -535,7 +985,7 @@ func (service *Service) sendXMPPRosterQuery(id string, to xmpp.Address, iqType s
 }
 
 func (service *Service) sendXMPPError(from *xmpp.Address, to *xmpp.Address, message string) error {
-	xmppMessage := &xmpp.Message{
+	xmppMessage := &messageStanza{
 		Header: xmpp.Header{
 			From: from,
 			To:   to,
@@ -550,7 +1000,7 @@ func (service *Service) sendXMPPError(from *xmpp.Address, to *xmpp.Address, mess
 }
 
 func (service *Service) sendXMPPPresence(from *xmpp.Address, to *xmpp.Address, presenceType string, status string) error {
-	xmppPresence := &xmpp.Presence{
+	xmppPresence := &presenceStanza{
 		Header: xmpp.Header{
 			From: from,
 			To:   to,
@@ -564,25 +1014,78 @@ func (service *Service) sendXMPPPresence(from *xmpp.Address, to *xmpp.Address, p
 	return nil
 }
 
-func (service *Service) addressForPhoneNumber(phoneNumber string) (xmpp.Address, bool) {
+func (service *Service) sendXMPPIq(iq *iqStanza) error {
+	if !service.sendWithin(5*time.Second, iq) {
+		return errors.New("Timed out when sending XMPP iq stanza")
+	}
+	return nil
+}
+
+// sendXMPPIqAndWait sends an iq stanza of type get or set and waits for the
+// corresponding result.  An error is returned if the response has type error.
+func (service *Service) sendXMPPIqAndWait(ctx context.Context, iq *iqStanza) (*iqStanza, error) {
+	if iq.ID == "" {
+		iq.ID = xmpp.RandomID()
+	}
+	responseChan := make(chan *iqStanza, 1)
+	service.pendingIqsMu.Lock()
+	service.pendingIqs[iq.ID] = responseChan
+	service.pendingIqsMu.Unlock()
+	defer func() {
+		service.pendingIqsMu.Lock()
+		delete(service.pendingIqs, iq.ID)
+		service.pendingIqsMu.Unlock()
+	}()
+
+	if err := service.sendXMPPIq(iq); err != nil {
+		return nil, err
+	}
+	select {
+	case response := <-responseChan:
+		if response.Type == "error" {
+			if response.Error != nil {
+				return nil, fmt.Errorf("XMPP error: %s", response.Error.Condition)
+			}
+			return nil, errors.New("XMPP error")
+		}
+		return response, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (service *Service) deliverIqResponse(iq *iqStanza) bool {
+	service.pendingIqsMu.Lock()
+	responseChan, exists := service.pendingIqs[iq.ID]
+	delete(service.pendingIqs, iq.ID)
+	service.pendingIqsMu.Unlock()
+	if exists {
+		responseChan <- iq
+	}
+	return exists
+}
+
+func (service *Service) userForPhoneNumber(phoneNumber string) (xmpp.Address, user, bool) {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
 	for address, user := range service.users {
 		if user.phoneNumber == phoneNumber {
-			return address, true
+			return address, user, true
 		}
 	}
-	return xmpp.Address{}, false
+	return xmpp.Address{}, user{}, false
+}
+
+func (service *Service) getDefaultPrefix() string {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	return service.defaultPrefix
 }
 
 func (service *Service) canonPhoneNumber(phoneNumber string) (string, error) {
-	if !strings.HasPrefix(phoneNumber, "+") {
-		if service.defaultPrefix == "" {
-			return "", errors.New("does not start with + (please prefix number with + and a country code, or configure the default_prefix option)")
-		}
-		phoneNumber = service.defaultPrefix + phoneNumber
-	}
-	return phoneNumber, validatePhoneNumber(phoneNumber)
+	return parsePhoneNumber(phoneNumber, service.getDefaultPrefix())
 }
 
 func (service *Service) friendlyPhoneNumber(phoneNumber string) string {
-	return strings.TrimPrefix(phoneNumber, service.defaultPrefix)
+	return localPhoneNumber(phoneNumber, service.getDefaultPrefix())
 }
@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+// Package smsencoding determines how text will be encoded when sent as an SMS
+// and how many segments it will occupy.
+package smsencoding
+
+import (
+	"unicode/utf16"
+)
+
+type Encoding int
+
+const (
+	GSM7 Encoding = iota // 3GPP TS 23.038 default alphabet, 7 bits per character
+	UCS2                 // UTF-16, used when the text contains characters outside the GSM-7 alphabet
+)
+
+func (encoding Encoding) String() string {
+	switch encoding {
+	case GSM7:
+		return "GSM-7"
+	case UCS2:
+		return "UCS-2"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	gsm7SingleLength  = 160 // septets in an unsegmented message
+	gsm7SegmentLength = 153 // septets in each segment of a concatenated message
+	ucs2SingleLength  = 70  // UTF-16 code units in an unsegmented message
+	ucs2SegmentLength = 67  // UTF-16 code units in each segment of a concatenated message
+)
+
+// gsm7Basic is the GSM-7 default alphabet; each character occupies one septet
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// gsm7Extension is the GSM-7 extension table; each character occupies two
+// septets (an escape followed by the character)
+const gsm7Extension = "\f^{}\\[~]|€"
+
+var gsm7Septets = make(map[rune]int)
+
+func init() {
+	for _, r := range gsm7Basic {
+		gsm7Septets[r] = 1
+	}
+	for _, r := range gsm7Extension {
+		gsm7Septets[r] = 2
+	}
+}
+
+// Info describes how a text would be sent as an SMS
+type Info struct {
+	Encoding Encoding
+	Length   int // in septets for GSM-7, or UTF-16 code units for UCS-2
+	Segments int
+}
+
+// IsGSM7 reports whether every character in text is in the GSM-7 alphabet
+func IsGSM7(text string) bool {
+	for _, r := range text {
+		if gsm7Septets[r] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Analyze returns the encoding, length, and number of segments of text.
+// A character is never split across segments, so concatenated messages may
+// contain fewer than the maximum number of septets or code units per segment.
+func Analyze(text string) Info {
+	var units []int
+	if IsGSM7(text) {
+		for _, r := range text {
+			units = append(units, gsm7Septets[r])
+		}
+		return analyzeUnits(GSM7, units, gsm7SingleLength, gsm7SegmentLength)
+	}
+	for _, r := range text {
+		units = append(units, utf16.RuneLen(r))
+	}
+	return analyzeUnits(UCS2, units, ucs2SingleLength, ucs2SegmentLength)
+}
+
+func analyzeUnits(encoding Encoding, units []int, singleLength int, segmentLength int) Info {
+	info := Info{Encoding: encoding, Segments: 1}
+	for _, n := range units {
+		info.Length += n
+	}
+	if info.Length <= singleLength {
+		return info
+	}
+	info.Segments = 0
+	remaining := 0
+	for _, n := range units {
+		if n > remaining {
+			info.Segments++
+			remaining = segmentLength
+		}
+		remaining -= n
+	}
+	return info
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsencoding
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want Info
+	}{
+		{"empty", "", Info{GSM7, 0, 1}},
+		{"basic", "Hello, world!", Info{GSM7, 13, 1}},
+		{"basic alphabet accents", "Ça va? À bientôt", Info{UCS2, 16, 1}},
+		{"GSM-7 accents", "déjà vu", Info{GSM7, 7, 1}},
+		{"extension character", "€5", Info{GSM7, 3, 1}},
+		{"all extension characters", "\f^{}\\[~]|€", Info{GSM7, 20, 1}},
+		{"GSM-7 single segment limit", strings.Repeat("a", 160), Info{GSM7, 160, 1}},
+		{"GSM-7 just over single segment", strings.Repeat("a", 161), Info{GSM7, 161, 2}},
+		{"GSM-7 two full segments", strings.Repeat("a", 306), Info{GSM7, 306, 2}},
+		{"GSM-7 just over two segments", strings.Repeat("a", 307), Info{GSM7, 307, 3}},
+		{"extension character at single segment limit", strings.Repeat("a", 158) + "€", Info{GSM7, 160, 1}},
+		{"extension character just over single segment", strings.Repeat("a", 159) + "€", Info{GSM7, 161, 2}},
+		// The escape and the character can't be split across segments, so
+		// the first segment holds only 152 septets
+		{"extension character at segment boundary", strings.Repeat("a", 152) + "€" + strings.Repeat("a", 152), Info{GSM7, 306, 3}},
+		{"extension character before segment boundary", strings.Repeat("a", 151) + "€" + strings.Repeat("a", 153), Info{GSM7, 306, 2}},
+		{"UCS-2", "Привет", Info{UCS2, 6, 1}},
+		{"UCS-2 single segment limit", strings.Repeat("я", 70), Info{UCS2, 70, 1}},
+		{"UCS-2 just over single segment", strings.Repeat("я", 71), Info{UCS2, 71, 2}},
+		{"UCS-2 two full segments", strings.Repeat("я", 134), Info{UCS2, 134, 2}},
+		{"UCS-2 just over two segments", strings.Repeat("я", 135), Info{UCS2, 135, 3}},
+		{"surrogate pair", "👍", Info{UCS2, 2, 1}},
+		{"surrogate pairs at single segment limit", strings.Repeat("👍", 35), Info{UCS2, 70, 1}},
+		{"surrogate pairs just over single segment", strings.Repeat("👍", 35) + "a", Info{UCS2, 71, 2}},
+		// A surrogate pair can't be split across segments, so the first
+		// segment holds only 66 code units
+		{"surrogate pair at segment boundary", strings.Repeat("a", 66) + "👍" + strings.Repeat("a", 66), Info{UCS2, 134, 3}},
+		{"surrogate pair before segment boundary", strings.Repeat("a", 65) + "👍" + strings.Repeat("a", 67), Info{UCS2, 134, 2}},
+		{"one emoji makes the whole message UCS-2", strings.Repeat("a", 100) + "😀", Info{UCS2, 102, 2}},
+	}
+	for _, test := range tests {
+		if got := Analyze(test.text); got != test.want {
+			t.Errorf("%s: Analyze(%q) = %+v, want %+v", test.name, test.text, got, test.want)
+		}
+	}
+}
+
+func TestIsGSM7(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"", true},
+		{"Hello @ $5 £3 ¥1 §2", true},
+		{"{[~]}", true},
+		{"\r\n", true},
+		{"\t", false},
+		{"“quoted”", false},
+		{"ç", false},
+		{"Ç", true},
+		{"👍", false},
+	}
+	for _, test := range tests {
+		if got := IsGSM7(test.text); got != test.want {
+			t.Errorf("IsGSM7(%q) = %v, want %v", test.text, got, test.want)
+		}
+	}
+}
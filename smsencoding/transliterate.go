@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsencoding
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// replacements maps characters which are outside the GSM-7 alphabet, but are
+// commonly inserted by phone keyboards and word processors, to GSM-7 equivalents
+var replacements = map[rune]string{
+	'‘': "'", '’': "'", '‚': "'", '‛': "'", '′': "'", '´': "'", '`': "'",
+	'“': "\"", '”': "\"", '„': "\"", '‟': "\"", '″': "\"", '«': "\"", '»': "\"",
+	'‐': "-", '‑': "-", '‒': "-", '–': "-", '—': "-", '―': "-", '−': "-",
+	'…': "...", '•': "*", '·': ".", '\t': " ",
+	'\u00A0': " ", '\u2002': " ", '\u2003': " ", '\u2009': " ", '\u202F': " ", // non-breaking and other fixed-width spaces
+	'\u200B': "", '\u200D': "", '\uFEFF': "", // zero-width spaces and joiners
+	'ç': "Ç", 'ı': "i",
+	'Œ': "OE", 'œ': "oe", 'Ĳ': "IJ", 'ĳ': "ij",
+}
+
+// Transliterate replaces characters which are outside the GSM-7 alphabet with
+// similar characters which are in it, such as straight quotes for smart quotes,
+// and letters without accents for accented letters that GSM-7 lacks.
+// Characters with no equivalent, such as emoji, are left alone, so the result
+// is GSM-7 only if IsGSM7 reports so.
+func Transliterate(text string) string {
+	if IsGSM7(text) {
+		return text
+	}
+	var result strings.Builder
+	for _, r := range text {
+		if gsm7Septets[r] != 0 {
+			result.WriteRune(r)
+		} else if replacement, ok := replacements[r]; ok {
+			result.WriteString(replacement)
+		} else if base := stripAccent(r); gsm7Septets[base] != 0 {
+			result.WriteRune(base)
+		} else {
+			result.WriteRune(r)
+		}
+	}
+	return result.String()
+}
+
+// stripAccent returns the base character of r's canonical decomposition,
+// e.g. 'a' for 'á'
+func stripAccent(r rune) rune {
+	for _, base := range norm.NFD.String(string(r)) {
+		return base
+	}
+	return r
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"net/url"
+
+	"github.com/emersion/go-vcard"
+	"github.com/emersion/go-webdav/carddav"
+)
+
+// contactSource is where the contacts in a roster come from
+type contactSource interface {
+	// sync updates addrbook with the current contacts from the source,
+	// setting addrbook.changed if any of them changed
+	sync(ctx context.Context, addrbook *addressBook) error
+}
+
+// newContactSource returns the contact source for a URL from the rosters
+// config, which determines the type of source:
+//
+//	https://...         CardDAV address book
+//	file:///path        vCard file, or directory of vCard files
+//	ldap[s]://host/base LDAP directory
+func newContactSource(sourceURL string) (contactSource, error) {
+	parsedURL, err := url.Parse(sourceURL)
+	if err != nil {
+		return nil, errors.New("is not a valid URL") // don't return err since it contains the URL, which may contain a password
+	}
+	switch parsedURL.Scheme {
+	case "http", "https":
+		if err := checkHTTPURL(sourceURL); err != nil {
+			return nil, err
+		}
+		client, err := carddav.NewClient(http.DefaultClient, sourceURL)
+		if err != nil {
+			return nil, err
+		}
+		return &carddavSource{url: sourceURL, client: client}, nil
+	case "file":
+		return newVcardFileSource(parsedURL)
+	case "ldap", "ldaps":
+		return newLDAPSource(parsedURL)
+	default:
+		return nil, errors.New("has unsupported scheme (must be https, file, ldap, or ldaps)")
+	}
+}
+
+type carddavSource struct {
+	url    string
+	client *carddav.Client
+}
+
+func (source *carddavSource) sync(ctx context.Context, addrbook *addressBook) error {
+	return addrbook.download(ctx, source.client)
+}
+
+// replaceEntries replaces all of the address book's entries, for sources
+// which can only be downloaded in full, and sets addrbook.changed if they
+// differ from the current entries
+func (addrbook *addressBook) replaceEntries(name string, entries map[string]carddav.AddressObject, avatars map[string]*avatar) {
+	changed := addrbook.entries == nil || name != addrbook.name || len(entries) != len(addrbook.entries)
+	for path, object := range entries {
+		if changed {
+			break
+		}
+		oldObject, exists := addrbook.entries[path]
+		changed = !exists || !vcardsEqual(object.Card, oldObject.Card) || avatarHash(&contact{avatar: avatars[path]}) != avatarHash(&contact{avatar: addrbook.avatars[path]})
+	}
+	if !changed {
+		return
+	}
+	addrbook.name = name
+	addrbook.entries = entries
+	addrbook.avatars = avatars
+	addrbook.changed = true
+	addrbook.dirty = true
+}
+
+func vcardsEqual(a, b vcard.Card) bool {
+	var aText, bText bytes.Buffer
+	if vcard.NewEncoder(&aText).Encode(a) != nil || vcard.NewEncoder(&bText).Encode(b) != nil {
+		return false
+	}
+	return bytes.Equal(aText.Bytes(), bText.Bytes())
+}
+
+// merge returns an address book containing the entries of both addrbook and
+// shared, the organization-wide address book.  Entries from addrbook take
+// precedence when both contain the same phone number.
+func (addrbook *addressBook) merge(shared *addressBook) *addressBook {
+	if shared == nil {
+		return addrbook
+	}
+	merged := &addressBook{
+		name:      addrbook.name,
+		entries:   make(map[string]carddav.AddressObject, len(addrbook.entries)+len(shared.entries)),
+		avatars:   make(map[string]*avatar),
+		order:     addrbook.sortedPaths(),
+		bookNames: make(map[string]string, len(shared.entries)),
+	}
+	for path, object := range addrbook.entries {
+		merged.entries[path] = object
+	}
+	for path, avatar := range addrbook.avatars {
+		merged.avatars[path] = avatar
+	}
+	for _, path := range shared.sortedPaths() {
+		sharedPath := "shared:" + path
+		merged.entries[sharedPath] = shared.entries[path]
+		merged.bookNames[sharedPath] = shared.name
+		if avatar := shared.avatars[path]; avatar != nil {
+			merged.avatars[sharedPath] = avatar
+		}
+		merged.order = append(merged.order, sharedPath)
+	}
+	return merged
+}
+
+// snapshot returns a copy of the address book's entries which is safe to use
+// from other goroutines while addrbook continues to be synchronized
+func (addrbook *addressBook) snapshot() *addressBook {
+	return &addressBook{
+		name:    addrbook.name,
+		entries: maps.Clone(addrbook.entries),
+		avatars: maps.Clone(addrbook.avatars),
+	}
+}
@@ -0,0 +1,496 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"encoding/xml"
+
+	"src.agwa.name/go-xmpp"
+)
+
+// The stanzas exchanged with the XMPP server, including the extension
+// elements that sms-over-xmpp understands.  Only addresses and stanza
+// headers come from go-xmpp.
+
+const (
+	componentNS = "jabber:component:accept"
+	stanzasNS   = "urn:ietf:params:xml:ns:xmpp-stanzas"
+
+	headlineMessage xmpp.MessageType = "headline"
+)
+
+type messageStanza struct {
+	XMLName xml.Name `xml:"jabber:component:accept message"`
+	xmpp.Header
+	Type          xmpp.MessageType `xml:"type,attr,omitempty"`
+	Body          string           `xml:"body,omitempty"`
+	Nick          string           `xml:"http://jabber.org/protocol/nick nick,omitempty"`
+	OutOfBandData []outOfBandData  `xml:"jabber:x:oob x"`
+	FileSharing   []fileSharing    `xml:"urn:xmpp:sfs:0 file-sharing,omitempty"`
+	References    []reference      `xml:"urn:xmpp:reference:0 reference,omitempty"`
+	chatStateElements
+	Replace     *replaceElement   `xml:"urn:xmpp:message-correct:0 replace"`
+	Retract     *retractElement   `xml:"urn:xmpp:message-retract:1 retract"`
+	Reactions   *reactionsElement `xml:"urn:xmpp:reactions:0 reactions"`
+	Fallback    []fallbackElement `xml:"urn:xmpp:fallback:0 fallback"`
+	Reply       *replyElement     `xml:"urn:xmpp:reply:0 reply"`
+	PubSubEvent *pubSubEvent      `xml:"http://jabber.org/protocol/pubsub#event event"`
+	Error       *stanzaError      `xml:"error"`
+}
+
+type presenceStanza struct {
+	XMLName xml.Name `xml:"jabber:component:accept presence"`
+	xmpp.Header
+	Type        string       `xml:"type,attr,omitempty"`
+	Status      string       `xml:"status,omitempty"`
+	VCardUpdate *vcardUpdate `xml:"vcard-temp:x:update x"`
+	Error       *stanzaError `xml:"error"`
+}
+
+type iqStanza struct {
+	XMLName xml.Name `xml:"jabber:component:accept iq"`
+	xmpp.Header
+	Type          string         `xml:"type,attr"`
+	RosterQuery   *rosterQuery   `xml:"jabber:iq:roster query"`
+	DiscoInfo     *discoInfo     `xml:"http://jabber.org/protocol/disco#info query"`
+	DiscoItems    *discoItems    `xml:"http://jabber.org/protocol/disco#items query"`
+	Command       *adHocCommand  `xml:"http://jabber.org/protocol/commands command"`
+	UploadRequest *uploadRequest `xml:"urn:xmpp:http:upload:0 request"`
+	UploadSlot    *uploadSlot    `xml:"urn:xmpp:http:upload:0 slot"`
+	VCard         *vcardTemp     `xml:"vcard-temp vCard"`
+	VCard4        *vcard4        `xml:"urn:ietf:params:xml:ns:vcard-4.0 vcard"`
+	PubSub        *pubSub        `xml:"http://jabber.org/protocol/pubsub pubsub"`
+	Error         *stanzaError   `xml:"error"`
+}
+
+// stanzaError is an RFC 6120 stanza error, such as
+// <error type="cancel"><item-not-found xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/></error>
+type stanzaError struct {
+	Type      string // e.g. "cancel", "auth"
+	Condition string // e.g. "item-not-found"
+	Text      string
+}
+
+func (e *stanzaError) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "type"}, Value: e.Type})
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	condition := xml.StartElement{Name: xml.Name{Space: stanzasNS, Local: e.Condition}}
+	if err := enc.EncodeToken(condition); err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(condition.End()); err != nil {
+		return err
+	}
+	if e.Text != "" {
+		if err := enc.EncodeElement(e.Text, xml.StartElement{Name: xml.Name{Space: stanzasNS, Local: "text"}}); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+func (e *stanzaError) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "type" {
+			e.Type = attr.Value
+		}
+	}
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if token.Name.Space == stanzasNS && token.Name.Local == "text" {
+				if err := dec.DecodeElement(&e.Text, &token); err != nil {
+					return err
+				}
+				continue
+			}
+			if token.Name.Space == stanzasNS && e.Condition == "" {
+				e.Condition = token.Name.Local
+			}
+			if err := dec.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// emptyElement is a flag represented by the presence of an empty element,
+// such as <required/>
+type emptyElement bool
+
+func (flag emptyElement) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	if !flag {
+		return nil
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	return enc.EncodeToken(start.End())
+}
+
+func (flag *emptyElement) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	*flag = true
+	return dec.Skip()
+}
+
+// Roster management (RFC 6121)
+
+type rosterItem struct {
+	JID          xmpp.Address `xml:"jid,attr"`
+	Name         string       `xml:"name,attr,omitempty"`
+	Subscription string       `xml:"subscription,attr,omitempty"`
+	Groups       []string     `xml:"group"`
+}
+
+type rosterQuery struct {
+	Items []rosterItem `xml:"item"`
+}
+
+// Out of Band Data (XEP-0066)
+
+type outOfBandData struct {
+	URL  string `xml:"url"`
+	Desc string `xml:"desc,omitempty"`
+}
+
+// Stateless File Sharing (XEP-0447) and Stateless Inline Media Sharing
+// (XEP-0385)
+
+type fileMetadata struct {
+	MediaType string `xml:"media-type,omitempty"`
+	Name      string `xml:"name,omitempty"`
+	Size      int64  `xml:"size,omitempty"`
+	Desc      string `xml:"desc,omitempty"`
+}
+
+type urlData struct {
+	Target string `xml:"target,attr"`
+}
+
+type fileSharing struct {
+	Disposition string       `xml:"disposition,attr,omitempty"`
+	File        fileMetadata `xml:"urn:xmpp:file:metadata:0 file"`
+	Sources     []urlData    `xml:"sources>http://jabber.org/protocol/url-data url-data"`
+}
+
+type mediaSharing struct {
+	File    fileMetadata `xml:"urn:xmpp:jingle:apps:file-transfer:5 file"`
+	Sources []reference  `xml:"sources>urn:xmpp:reference:0 reference"`
+}
+
+type reference struct {
+	Type         string        `xml:"type,attr"`
+	URI          string        `xml:"uri,attr,omitempty"`
+	MediaSharing *mediaSharing `xml:"urn:xmpp:sims:1 media-sharing"`
+}
+
+// Chat State Notifications (XEP-0085)
+
+type chatState string
+
+const (
+	chatStateActive    chatState = "active"
+	chatStateComposing chatState = "composing"
+	chatStatePaused    chatState = "paused"
+	chatStateInactive  chatState = "inactive"
+	chatStateGone      chatState = "gone"
+)
+
+// chatStateElements represents a chat state as an element named after the
+// state, at most one of which is present in a message
+type chatStateElements struct {
+	Active    *struct{} `xml:"http://jabber.org/protocol/chatstates active"`
+	Composing *struct{} `xml:"http://jabber.org/protocol/chatstates composing"`
+	Paused    *struct{} `xml:"http://jabber.org/protocol/chatstates paused"`
+	Inactive  *struct{} `xml:"http://jabber.org/protocol/chatstates inactive"`
+	Gone      *struct{} `xml:"http://jabber.org/protocol/chatstates gone"`
+}
+
+func makeChatState(state chatState) chatStateElements {
+	var elements chatStateElements
+	switch state {
+	case chatStateActive:
+		elements.Active = &struct{}{}
+	case chatStateComposing:
+		elements.Composing = &struct{}{}
+	case chatStatePaused:
+		elements.Paused = &struct{}{}
+	case chatStateInactive:
+		elements.Inactive = &struct{}{}
+	case chatStateGone:
+		elements.Gone = &struct{}{}
+	}
+	return elements
+}
+
+// chatState returns the chat state, or "" if the message doesn't have one
+func (elements chatStateElements) chatState() chatState {
+	switch {
+	case elements.Active != nil:
+		return chatStateActive
+	case elements.Composing != nil:
+		return chatStateComposing
+	case elements.Paused != nil:
+		return chatStatePaused
+	case elements.Inactive != nil:
+		return chatStateInactive
+	case elements.Gone != nil:
+		return chatStateGone
+	default:
+		return ""
+	}
+}
+
+// Last Message Correction (XEP-0308), Message Retraction (XEP-0424), Message
+// Reactions (XEP-0444), Fallback Indication (XEP-0428), and Message Replies
+// (XEP-0461)
+
+type replaceElement struct {
+	ID string `xml:"id,attr"`
+}
+
+type retractElement struct {
+	ID string `xml:"id,attr"`
+}
+
+type reactionsElement struct {
+	ID        string   `xml:"id,attr"`
+	Reactions []string `xml:"reaction"`
+}
+
+type fallbackRange struct {
+	Start int `xml:"start,attr"`
+	End   int `xml:"end,attr"`
+}
+
+type fallbackElement struct {
+	For  string          `xml:"for,attr"`
+	Body []fallbackRange `xml:"body"`
+}
+
+type replyElement struct {
+	To *xmpp.Address `xml:"to,attr,omitempty"`
+	ID string        `xml:"id,attr"`
+}
+
+// Service Discovery (XEP-0030)
+
+type discoIdentity struct {
+	Category string `xml:"category,attr"`
+	Type     string `xml:"type,attr"`
+	Name     string `xml:"name,attr,omitempty"`
+}
+
+type discoFeature struct {
+	Var string `xml:"var,attr"`
+}
+
+type discoInfo struct {
+	Node       string          `xml:"node,attr,omitempty"`
+	Identities []discoIdentity `xml:"identity"`
+	Features   []discoFeature  `xml:"feature"`
+}
+
+type discoItem struct {
+	JID  xmpp.Address `xml:"jid,attr"`
+	Node string       `xml:"node,attr,omitempty"`
+	Name string       `xml:"name,attr,omitempty"`
+}
+
+type discoItems struct {
+	Node  string      `xml:"node,attr,omitempty"`
+	Items []discoItem `xml:"item"`
+}
+
+// Ad-Hoc Commands (XEP-0050) and Data Forms (XEP-0004)
+
+type commandNote struct {
+	Type string `xml:"type,attr,omitempty"`
+	Text string `xml:",chardata"`
+}
+
+type adHocCommand struct {
+	Node      string        `xml:"node,attr"`
+	SessionID string        `xml:"sessionid,attr,omitempty"`
+	Action    string        `xml:"action,attr,omitempty"`
+	Status    string        `xml:"status,attr,omitempty"`
+	Notes     []commandNote `xml:"note"`
+	Form      *dataForm     `xml:"jabber:x:data x"`
+}
+
+type dataFormOption struct {
+	Label string `xml:"label,attr,omitempty"`
+	Value string `xml:"value"`
+}
+
+type dataFormField struct {
+	Var      string           `xml:"var,attr,omitempty"`
+	Type     string           `xml:"type,attr,omitempty"`
+	Label    string           `xml:"label,attr,omitempty"`
+	Required emptyElement     `xml:"required"`
+	Values   []string         `xml:"value"`
+	Options  []dataFormOption `xml:"option"`
+}
+
+type dataForm struct {
+	Type         string          `xml:"type,attr"`
+	Title        string          `xml:"title,omitempty"`
+	Instructions string          `xml:"instructions,omitempty"`
+	Fields       []dataFormField `xml:"field"`
+}
+
+// HTTP File Upload (XEP-0363)
+
+type uploadRequest struct {
+	Filename    string `xml:"filename,attr"`
+	Size        int64  `xml:"size,attr"`
+	ContentType string `xml:"content-type,attr,omitempty"`
+}
+
+type uploadHeader struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+type uploadPut struct {
+	URL     string         `xml:"url,attr"`
+	Headers []uploadHeader `xml:"header"`
+}
+
+type uploadGet struct {
+	URL string `xml:"url,attr"`
+}
+
+type uploadSlot struct {
+	Put uploadPut `xml:"put"`
+	Get uploadGet `xml:"get"`
+}
+
+// vcard-temp (XEP-0054), vCard4 Over XMPP (XEP-0292), and vCard-Based
+// Avatars (XEP-0153)
+
+type vcardTemp struct {
+	FN    string      `xml:"FN,omitempty"`
+	Org   *vcardOrg   `xml:"ORG"`
+	Tel   []vcardTel  `xml:"TEL"`
+	Photo *vcardPhoto `xml:"PHOTO"`
+}
+
+type vcardOrg struct {
+	OrgName string   `xml:"ORGNAME"`
+	OrgUnit []string `xml:"ORGUNIT"`
+}
+
+// vcardTel is a TEL element, whose types are represented by empty elements
+// such as <CELL/>
+type vcardTel struct {
+	Types  []string
+	Number string
+}
+
+func (tel *vcardTel) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, t := range tel.Types {
+		if err := (emptyElement(true)).MarshalXML(enc, xml.StartElement{Name: xml.Name{Local: t}}); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeElement(tel.Number, xml.StartElement{Name: xml.Name{Local: "NUMBER"}}); err != nil {
+		return err
+	}
+	return enc.EncodeToken(start.End())
+}
+
+type vcardPhoto struct {
+	Type   string `xml:"TYPE,omitempty"`
+	Binval string `xml:"BINVAL,omitempty"` // base64-encoded
+	Extval string `xml:"EXTVAL,omitempty"`
+}
+
+type vcard4 struct {
+	FN    string      `xml:"fn>text,omitempty"`
+	Org   []string    `xml:"org>text"`
+	Tel   []vcard4Tel `xml:"tel"`
+	Photo string      `xml:"photo>uri,omitempty"`
+}
+
+type vcard4Tel struct {
+	Types []string `xml:"parameters>type>text"`
+	URI   string   `xml:"uri"`
+}
+
+type vcardUpdate struct {
+	Photo *string `xml:"photo"`
+}
+
+// Publish-Subscribe (XEP-0060) and User Avatar (XEP-0084)
+
+type pubSub struct {
+	Items *pubSubItems `xml:"items"`
+}
+
+type pubSubEvent struct {
+	Items *pubSubItems `xml:"items"`
+}
+
+type pubSubItems struct {
+	Node  string       `xml:"node,attr"`
+	Items []pubSubItem `xml:"item"`
+}
+
+type pubSubItem struct {
+	ID             string          `xml:"id,attr,omitempty"`
+	AvatarData     *avatarData     `xml:"urn:xmpp:avatar:data data"`
+	AvatarMetadata *avatarMetadata `xml:"urn:xmpp:avatar:metadata metadata"`
+}
+
+type avatarData struct {
+	Data string `xml:",chardata"` // base64-encoded
+}
+
+type avatarMetadata struct {
+	Info []avatarInfo `xml:"info"`
+}
+
+type avatarInfo struct {
+	Bytes  int    `xml:"bytes,attr"`
+	ID     string `xml:"id,attr"`
+	Type   string `xml:"type,attr"`
+	Width  int    `xml:"width,attr,omitempty"`
+	Height int    `xml:"height,attr,omitempty"`
+}
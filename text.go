@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"src.agwa.name/go-xmpp"
+	"src.agwa.name/sms-over-xmpp/smsencoding"
+)
+
+func (service *Service) getTextOptions() (bool, int) {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	return service.transliterate, service.segmentNotice
+}
+
+// segmentConfirmTimeout is how long the user has to confirm that they want
+// to send a message which is longer than segment_notice segments
+const segmentConfirmTimeout = 5 * time.Minute
+
+type segmentConfirmKey struct {
+	user    xmpp.Address // bare JID
+	contact xmpp.Address
+}
+
+type segmentConfirm struct {
+	body    string
+	expires time.Time
+}
+
+// prepareText transliterates the message body to GSM-7 if transliteration is
+// enabled and doing so avoids UCS-2, which would more than double the number of
+// segments.  Since providers charge for each segment, if the message will
+// occupy more than segment_notice segments, it is not sent; instead the user
+// is told how many segments it occupies, and the message is only sent if they
+// send it again within segmentConfirmTimeout.  prepareText returns false if
+// the message should not be sent.
+func (service *Service) prepareText(contact *xmpp.Address, sender *xmpp.Address, message *Message) bool {
+	if message.Body == "" {
+		return true
+	}
+	transliterate, segmentNotice := service.getTextOptions()
+	if transliterate {
+		if transliterated := smsencoding.Transliterate(message.Body); smsencoding.IsGSM7(transliterated) {
+			message.Body = transliterated
+		}
+	}
+	if len(message.MediaURLs) > 0 || segmentNotice == 0 {
+		return true
+	}
+	info := smsencoding.Analyze(message.Body)
+	if info.Segments <= segmentNotice {
+		return true
+	}
+	if service.confirmSegments(segmentConfirmKey{user: *sender.Bare(), contact: *contact.Bare()}, message.Body) {
+		return true
+	}
+	notice := fmt.Sprintf("Your message to %s was not sent because it is %d SMS segments long (%s encoding)", contact.LocalPart, info.Segments, info.Encoding)
+	if info.Encoding == smsencoding.UCS2 {
+		notice += ", since it contains characters, such as emoji, which aren't in the GSM-7 alphabet"
+	}
+	notice += fmt.Sprintf(".  To send it anyway, send the same message again within %d minutes.", int(segmentConfirmTimeout/time.Minute))
+	if err := service.sendXMPPChat(xmpp.Address{DomainPart: service.xmppParams.Domain}, *sender, notice); err != nil {
+		log.Printf("Unable to send segment notice to %s: %s", sender, err)
+	}
+	return false
+}
+
+// confirmSegments returns true if the user already tried to send the same
+// long message to the contact within segmentConfirmTimeout.  Otherwise, it
+// remembers the message so that sending it again confirms it.
+func (service *Service) confirmSegments(key segmentConfirmKey, body string) bool {
+	service.segmentConfirmsMu.Lock()
+	defer service.segmentConfirmsMu.Unlock()
+
+	now := time.Now()
+	for k, pending := range service.segmentConfirms {
+		if now.After(pending.expires) {
+			delete(service.segmentConfirms, k)
+		}
+	}
+	if pending, exists := service.segmentConfirms[key]; exists && pending.body == body {
+		delete(service.segmentConfirms, key)
+		return true
+	}
+	service.segmentConfirms[key] = segmentConfirm{body: body, expires: now.Add(segmentConfirmTimeout)}
+	return false
+}
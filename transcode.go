@@ -0,0 +1,271 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gen2brain/heic"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	maxImageDimension = 2048       // images are scaled so that neither side is longer than this
+	minImageDimension = 320        // give up rather than scale images smaller than this
+	maxImagePixels    = 50_000_000 // refuse to decode images larger than this
+)
+
+var jpegQualities = []int{85, 70, 55}
+
+func (service *Service) outboundMediaDir() (string, string) {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	return service.mediaDir, service.publicURL
+}
+
+// prepareOutboundMedia fetches each of the message's attachments and checks
+// it against the provider's limits.  Images which exceed the limits are
+// converted and stored in media_dir, and the message is changed to refer to
+// the converted file.  Attachments which are within the limits are left alone.
+// If media_dir isn't set, attachments which exceed the limits are rejected.
+func (service *Service) prepareOutboundMedia(ctx context.Context, provider Provider, message *Message) error {
+	limiter, ok := provider.(MediaLimiter)
+	if !ok || len(message.MediaURLs) == 0 {
+		return nil
+	}
+	mediaDir, publicURL := service.outboundMediaDir()
+	limits := limiter.MediaLimits()
+
+	mediaURLs := make([]string, len(message.MediaURLs))
+	for i, mediaURL := range message.MediaURLs {
+		file, err := fetchOutboundMedia(ctx, mediaURL)
+		if err != nil {
+			return fmt.Errorf("error downloading %s: %w", mediaURL, err)
+		}
+		if limits.fits(file) {
+			mediaURLs[i] = mediaURL
+			continue
+		}
+		if mediaDir == "" {
+			return fmt.Errorf("%s exceeds the provider's limits (%s, %d bytes), and can't be converted because media_dir is not configured", file.filename, mediaContentType(file), len(file.data))
+		}
+		convertedFile, err := convertMedia(file, &limits)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.filename, err)
+		}
+		mediaURLs[i], err = storeMedia(filepath.Join(mediaDir, outboundMediaSubdir), strings.TrimSuffix(publicURL, "/")+"/media/"+outboundMediaSubdir, convertedFile)
+		if err != nil {
+			return fmt.Errorf("error storing converted media: %w", err)
+		}
+	}
+	message.MediaURLs = mediaURLs
+	return nil
+}
+
+// fetchOutboundMedia downloads an attachment sent by a user.  Since the URL
+// comes from an XMPP client, it is only fetched if it resolves to a public
+// address, so that it can't be used to reach services on the gateway's
+// network.
+func fetchOutboundMedia(ctx context.Context, mediaURL string) (*mediaFile, error) {
+	return downloadMedia(ctx, publicHTTPClient, nil, mediaURL, maxMediaSize)
+}
+
+var publicHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(network string, address string, conn syscall.RawConn) error {
+				addrPort, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if !isPublicAddr(addrPort.Addr()) {
+					return fmt.Errorf("%s is not a public address", addrPort.Addr())
+				}
+				return nil
+			},
+		}).DialContext,
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which
+// netip.Addr.IsPrivate doesn't include
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// fits reports whether the file can be sent without conversion
+func (limits *MediaLimits) fits(file *mediaFile) bool {
+	return limits.accepts(mediaContentType(file)) && len(file.data) <= limits.MaxSize
+}
+
+// convertMedia converts an image which doesn't fit within the limits to a
+// JPEG which does, scaling it down as necessary
+func convertMedia(file *mediaFile, limits *MediaLimits) (*mediaFile, error) {
+	contentType := mediaContentType(file)
+	if !strings.HasPrefix(contentType, "image/") {
+		if !limits.accepts(contentType) {
+			return nil, fmt.Errorf("%s attachments are not supported by this provider", contentType)
+		}
+		return nil, fmt.Errorf("attachment is too large (%d bytes; the limit is %d bytes)", len(file.data), limits.MaxSize)
+	}
+	if !limits.accepts("image/jpeg") {
+		return nil, fmt.Errorf("%s images are not supported by this provider", contentType)
+	}
+	img, err := decodeImage(file.data, contentType)
+	if err != nil {
+		return nil, err
+	}
+	data, err := compressImage(img, limits.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+	return &mediaFile{
+		filename:    strings.TrimSuffix(file.filename, path.Ext(file.filename)) + ".jpg",
+		contentType: "image/jpeg",
+		data:        data,
+	}, nil
+}
+
+// decodeImage decodes an image, refusing images with more than
+// maxImagePixels pixels, which would need an excessive amount of memory
+func decodeImage(data []byte, contentType string) (image.Image, error) {
+	decode := func(r io.Reader) (image.Image, error) {
+		img, _, err := image.Decode(r)
+		return img, err
+	}
+	decodeConfig := func(r io.Reader) (image.Config, error) {
+		config, _, err := image.DecodeConfig(r)
+		return config, err
+	}
+	if contentType == "image/heic" || contentType == "image/heif" {
+		decode, decodeConfig = heic.Decode, heic.DecodeConfig
+	}
+	config, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %s image: %w", contentType, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return nil, fmt.Errorf("image is too large to convert (%dx%d pixels)", config.Width, config.Height)
+	}
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %s image: %w", contentType, err)
+	}
+	return img, nil
+}
+
+// mediaContentType returns the media type (without parameters) of the file,
+// sniffing the contents if the server didn't provide a useful type
+func mediaContentType(file *mediaFile) string {
+	mediaType, _, err := mime.ParseMediaType(file.contentType)
+	if err != nil || mediaType == "application/octet-stream" {
+		if isHEIF(file.data) {
+			return "image/heic"
+		}
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(file.data))
+	}
+	return mediaType
+}
+
+// isHEIF reports whether data begins with an ISO base media file type box
+// identifying a HEIF/HEIC image, which http.DetectContentType doesn't recognize
+func isHEIF(data []byte) bool {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	switch string(data[8:12]) {
+	case "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1":
+		return true
+	}
+	return false
+}
+
+// compressImage encodes img as a JPEG no larger than maxSize bytes, reducing
+// the quality and then the dimensions until it fits
+func compressImage(img image.Image, maxSize int) ([]byte, error) {
+	bounds := img.Bounds()
+	longest := max(bounds.Dx(), bounds.Dy())
+	if longest > maxImageDimension {
+		longest = maxImageDimension
+	}
+	for {
+		scaled := scaleImage(img, longest)
+		for _, quality := range jpegQualities {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, err
+			}
+			if buf.Len() <= maxSize {
+				return buf.Bytes(), nil
+			}
+		}
+		if longest <= minImageDimension {
+			return nil, fmt.Errorf("image can't be compressed to fit within %d bytes", maxSize)
+		}
+		longest = max(minImageDimension, longest*3/4)
+	}
+}
+
+// scaleImage scales img so that its longest side is no longer than longest,
+// flattening any transparency onto a white background since JPEG lacks an alpha channel
+func scaleImage(img image.Image, longest int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width >= height && width > longest {
+		width, height = longest, max(1, height*longest/width)
+	} else if height > width && height > longest {
+		width, height = max(1, width*longest/height), longest
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(scaled, scaled.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Over, nil)
+	return scaled
+}
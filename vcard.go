@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/emersion/go-vcard"
+	"src.agwa.name/go-xmpp"
+)
+
+const (
+	vcardTempNS = "vcard-temp"
+	vcard4NS    = "urn:ietf:params:xml:ns:vcard-4.0"
+)
+
+// contactInfo is what's known about a contact JID, from the user's address
+// book if the contact is in it, or just the phone number otherwise
+type contactInfo struct {
+	name         string
+	organization []string // organization name followed by units
+	numbers      []contactNumber
+	avatar       *avatar // nil if there is no photo
+}
+
+type contactNumber struct {
+	number string // E.164
+	types  []string
+}
+
+// contact is an address book entry
+type contact struct {
+	card    vcard.Card
+	numbers []contactNumber
+	avatar  *avatar // nil if there is no photo
+}
+
+// setContacts replaces the user's contacts, returning the previous ones
+func (user *rosterUser) setContacts(contacts map[string]*contact) map[string]*contact {
+	user.contactsMu.Lock()
+	defer user.contactsMu.Unlock()
+	old := user.contacts
+	user.contacts = contacts
+	return old
+}
+
+// initContacts sets the user's contacts if they haven't been set yet
+func (user *rosterUser) initContacts(contacts map[string]*contact) {
+	user.contactsMu.Lock()
+	defer user.contactsMu.Unlock()
+	if user.contacts == nil {
+		user.contacts = contacts
+	}
+}
+
+func (user *rosterUser) lookupContact(phoneNumber string) (*contact, bool) {
+	user.contactsMu.Lock()
+	defer user.contactsMu.Unlock()
+	contact, exists := user.contacts[phoneNumber]
+	return contact, exists
+}
+
+func (service *Service) lookupContactInfo(userAddress xmpp.Address, contactAddress xmpp.Address) (*contactInfo, error) {
+	phoneNumber, err := service.canonPhoneNumber(contactAddress.LocalPart)
+	if err != nil {
+		return nil, err
+	}
+	if user, exists := service.lookupRosterUser(*userAddress.Bare()); exists {
+		if contact, exists := user.lookupContact(phoneNumber); exists {
+			return makeContactInfo(contact), nil
+		}
+	}
+	name := service.cachedCallerName(phoneNumber)
+	if name == "" {
+		name = formatPhoneNumber(phoneNumber)
+	}
+	return &contactInfo{
+		name:    name,
+		numbers: []contactNumber{{number: phoneNumber, types: []string{vcard.TypeCell}}},
+	}, nil
+}
+
+func makeContactInfo(contact *contact) *contactInfo {
+	card := contact.card
+	info := &contactInfo{
+		name:   card.PreferredValue(vcard.FieldFormattedName),
+		avatar: contact.avatar,
+	}
+	if org := card.PreferredValue(vcard.FieldOrganization); org != "" {
+		info.organization = strings.Split(org, ";")
+	}
+	info.numbers = contact.numbers
+	return info
+}
+
+func (info *contactInfo) vcardTemp() *vcardTemp {
+	card := &vcardTemp{FN: info.name}
+	if len(info.organization) > 0 {
+		card.Org = &vcardOrg{OrgName: info.organization[0], OrgUnit: info.organization[1:]}
+	}
+	for _, number := range info.numbers {
+		tel := vcardTel{Number: number.number}
+		for _, t := range number.types {
+			switch t = strings.ToUpper(t); t {
+			case "HOME", "WORK", "VOICE", "FAX", "PAGER", "MSG", "CELL", "VIDEO":
+				tel.Types = append(tel.Types, t)
+			}
+		}
+		card.Tel = append(card.Tel, tel)
+	}
+	if info.avatar != nil {
+		card.Photo = &vcardPhoto{Type: info.avatar.contentType, Binval: base64.StdEncoding.EncodeToString(info.avatar.data)}
+	}
+	return card
+}
+
+func (info *contactInfo) vcard4() *vcard4 {
+	card := &vcard4{FN: info.name, Org: info.organization}
+	for _, number := range info.numbers {
+		tel := vcard4Tel{URI: "tel:" + number.number}
+		for _, t := range number.types {
+			tel.Types = append(tel.Types, strings.ToLower(t))
+		}
+		card.Tel = append(card.Tel, tel)
+	}
+	if info.avatar != nil {
+		card.Photo = "data:" + info.avatar.contentType + ";base64," + base64.StdEncoding.EncodeToString(info.avatar.data)
+	}
+	return card
+}
+
+// receiveXMPPVCard answers vcard-temp (XEP-0054) and vCard4 (XEP-0292)
+// requests for contact JIDs
+func (service *Service) receiveXMPPVCard(ctx context.Context, iq *iqStanza) error {
+	if iq.From == nil || iq.To == nil {
+		return errors.New("Received malformed XMPP iq: From and To not set")
+	}
+	if iq.Type != "get" {
+		return nil
+	}
+	if _, userExists := service.lookupUser(*iq.From.Bare()); !userExists {
+		return service.sendXMPPIq(makeIqError(iq, "auth", "forbidden"))
+	}
+	if iq.To.LocalPart == "" {
+		return service.sendXMPPIq(makeIqError(iq, "cancel", "item-not-found"))
+	}
+	info, err := service.lookupContactInfo(*iq.From, *iq.To)
+	if err != nil {
+		return service.sendXMPPIq(makeIqError(iq, "cancel", "item-not-found"))
+	}
+
+	reply := makeIqReply(iq, "result")
+	if iq.VCard != nil {
+		reply.VCard = info.vcardTemp()
+	} else {
+		reply.VCard4 = info.vcard4()
+	}
+	return service.sendXMPPIq(reply)
+}
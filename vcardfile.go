@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-vcard"
+	"github.com/emersion/go-webdav/carddav"
+)
+
+// vcardFileSource reads contacts from a vCard file, such as one exported from
+// Google Contacts, or from every .vcf file in a directory.  The files are read
+// again whenever their modification times or sizes change.
+type vcardFileSource struct {
+	path        string
+	fingerprint string // modification times and sizes of the files when last read
+}
+
+func newVcardFileSource(fileURL *url.URL) (*vcardFileSource, error) {
+	if fileURL.Host != "" && fileURL.Host != "localhost" {
+		return nil, errors.New("file URL must not have a host")
+	}
+	if !filepath.IsAbs(fileURL.Path) {
+		return nil, errors.New("file URL must have an absolute path")
+	}
+	return &vcardFileSource{path: filepath.Clean(fileURL.Path)}, nil
+}
+
+// files returns the vCard files and their fingerprint
+func (source *vcardFileSource) files() ([]string, string, error) {
+	info, err := os.Stat(source.path)
+	if err != nil {
+		return nil, "", err
+	}
+	var files []string
+	if info.IsDir() {
+		dirEntries, err := os.ReadDir(source.path)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, dirEntry := range dirEntries {
+			if !dirEntry.IsDir() && strings.EqualFold(filepath.Ext(dirEntry.Name()), ".vcf") {
+				files = append(files, filepath.Join(source.path, dirEntry.Name()))
+			}
+		}
+	} else {
+		files = []string{source.path}
+	}
+
+	var fingerprint strings.Builder
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, "", err
+		}
+		fmt.Fprintf(&fingerprint, "%s %d %d\n", file, info.ModTime().UnixNano(), info.Size())
+	}
+	return files, fingerprint.String(), nil
+}
+
+func (source *vcardFileSource) sync(ctx context.Context, addrbook *addressBook) error {
+	files, fingerprint, err := source.files()
+	if err != nil {
+		return err
+	}
+	if fingerprint == source.fingerprint {
+		return nil
+	}
+
+	entries := make(map[string]carddav.AddressObject)
+	photoCards := make(map[string]vcard.Card)
+	for _, file := range files {
+		cards, err := readVcardFile(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		for i, card := range cards {
+			path := "file:" + filepath.Base(file) + "#"
+			if uid := card.Value(vcard.FieldUID); uid != "" {
+				path += uid
+			} else {
+				path += strconv.Itoa(i)
+			}
+			entries[path] = carddav.AddressObject{Path: path, Card: card}
+			photoCards[path] = card
+		}
+	}
+	avatars := loadVcardPhotos(ctx, photoCards)
+
+	name := strings.TrimSuffix(filepath.Base(source.path), filepath.Ext(source.path))
+	addrbook.replaceEntries(name, entries, avatars)
+	source.fingerprint = fingerprint
+	return nil
+}
+
+func readVcardFile(path string) ([]vcard.Card, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var cards []vcard.Card
+	decoder := vcard.NewDecoder(file)
+	for {
+		card, err := decoder.Decode()
+		if err == io.EOF {
+			return cards, nil
+		} else if err != nil {
+			return nil, err
+		}
+		cards = append(cards, card)
+	}
+}
@@ -0,0 +1,298 @@
+/*
+ * Copyright (c) 2026 Andrew Ayer
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ * Except as contained in this notice, the name(s) of the above copyright
+ * holders shall not be used in advertising or otherwise to promote the
+ * sale, use or other dealings in this Software without prior written
+ * authorization.
+ */
+
+package smsxmpp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"src.agwa.name/go-xmpp"
+)
+
+// WebDAV-Push (https://github.com/bitfireAT/webdav-push) lets a CardDAV
+// server notify sms-over-xmpp when an address book changes, by POSTing to a
+// push resource under public_url.  The push message itself is ignored; it
+// merely triggers a synchronization of the address book which the push
+// resource belongs to.
+const (
+	webdavPushNS = "https://bitfire.at/webdav-push"
+
+	pushExpiry             = 7 * 24 * time.Hour // how long to ask the server to keep a subscription
+	pushRenewBefore        = 24 * time.Hour     // renew subscriptions this long before they expire
+	pushRetryInterval      = time.Hour          // how long to wait before trying to subscribe again after failing
+	rosterPushPollInterval = 15 * time.Minute   // poll at least this often even when subscribed, in case a push is lost
+	pushTimeout            = 30 * time.Second
+)
+
+// pushSubscription is a WebDAV-Push subscription to changes to a user's
+// CardDAV address book
+type pushSubscription struct {
+	source          *carddavSource
+	registrationURL string    // "" if subscribing failed or isn't supported by the server
+	expires         time.Time // when to subscribe again
+}
+
+func (push *pushSubscription) active() bool {
+	return push != nil && push.registrationURL != "" && time.Now().Before(push.expires)
+}
+
+// renewPushSubscription subscribes to changes to the address book if the
+// server supports WebDAV-Push and public_url is set, and the subscription
+// doesn't exist or is about to expire.  It returns the current subscription.
+func (service *Service) renewPushSubscription(ctx context.Context, userJID xmpp.Address, user *rosterUser, source *carddavSource, push *pushSubscription) *pushSubscription {
+	if push != nil && time.Until(push.expires) > pushRenewBefore {
+		return push
+	}
+	if push != nil && push.registrationURL == "" && time.Now().Before(push.expires) {
+		return push
+	}
+	publicURL := service.getPublicURL()
+	if publicURL == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+	defer cancel()
+	retry := &pushSubscription{source: source, expires: time.Now().Add(pushRetryInterval)}
+	if supported, err := supportsWebPush(ctx, source.url); err != nil {
+		log.Printf("Unable to determine if address book for %s supports WebDAV-Push: %s", userJID, err)
+		return retry
+	} else if !supported {
+		retry.expires = time.Now().Add(pushRenewBefore)
+		return retry
+	}
+	pushResource := strings.TrimSuffix(publicURL, "/") + "/roster-push/" + user.pushToken
+	registrationURL, expires, err := registerPushSubscription(ctx, source.url, pushResource)
+	if err != nil {
+		log.Printf("Unable to subscribe to changes to address book for %s: %s", userJID, err)
+		return retry
+	}
+	return &pushSubscription{source: source, registrationURL: registrationURL, expires: expires}
+}
+
+// unregister deletes the subscription from the server, so that it stops
+// pushing to a push resource which no longer exists
+func (push *pushSubscription) unregister() {
+	if push == nil || push.registrationURL == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+	defer cancel()
+	resp, err := doWebDAVRequest(ctx, http.MethodDelete, push.source.url, push.registrationURL, nil)
+	if err != nil {
+		log.Printf("Unable to unsubscribe from address book changes: %s", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// supportsWebPush returns true if the collection's push transports include
+// Web Push
+func supportsWebPush(ctx context.Context, collectionURL string) (bool, error) {
+	const body = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:" xmlns:P="` + webdavPushNS + `"><prop><P:transports/></prop></propfind>`
+	resp, err := doWebDAVRequest(ctx, "PROPFIND", collectionURL, "", strings.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return false, fmt.Errorf("HTTP error: %s", resp.Status)
+	}
+
+	var multistatus struct {
+		Responses []struct {
+			Propstats []struct {
+				Prop struct {
+					Transports *struct {
+						WebPush *struct{} `xml:"https://bitfire.at/webdav-push web-push"`
+					} `xml:"https://bitfire.at/webdav-push transports"`
+				} `xml:"DAV: prop"`
+			} `xml:"DAV: propstat"`
+		} `xml:"DAV: response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&multistatus); err != nil {
+		return false, err
+	}
+	for _, response := range multistatus.Responses {
+		for _, propstat := range response.Propstats {
+			if transports := propstat.Prop.Transports; transports != nil && transports.WebPush != nil {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// registerPushSubscription asks the server to push to pushResource when the
+// collection changes, returning the URL of the registration and when it
+// expires.  The subscription includes a freshly-generated public key and
+// authentication secret, since some servers require them, but push messages
+// are never decrypted.
+func registerPushSubscription(ctx context.Context, collectionURL string, pushResource string) (string, time.Time, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	authSecret := make([]byte, 16)
+	rand.Read(authSecret)
+
+	var body bytes.Buffer
+	body.WriteString(xml.Header)
+	fmt.Fprintf(&body, `<push-register xmlns="%s"><subscription><web-push-subscription>`, webdavPushNS)
+	body.WriteString(`<push-resource>`)
+	xml.EscapeText(&body, []byte(pushResource))
+	body.WriteString(`</push-resource>`)
+	fmt.Fprintf(&body, `<content-encoding>aes128gcm</content-encoding><subscription-public-key type="p256dh">%s</subscription-public-key><auth-secret>%s</auth-secret>`,
+		base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), base64.RawURLEncoding.EncodeToString(authSecret))
+	body.WriteString(`</web-push-subscription></subscription>`)
+	body.WriteString(`<trigger><content-update><depth xmlns="DAV:">1</depth></content-update></trigger>`)
+	fmt.Fprintf(&body, `<expires>%s</expires></push-register>`, time.Now().Add(pushExpiry).UTC().Format(http.TimeFormat))
+
+	resp, err := doWebDAVRequest(ctx, http.MethodPost, collectionURL, "", &body)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return "", time.Time{}, fmt.Errorf("HTTP error: %s", resp.Status)
+	}
+	registrationURL := resp.Header.Get("Location")
+	if registrationURL == "" {
+		return "", time.Time{}, errors.New("server did not return the location of the registration")
+	}
+	expires, err := http.ParseTime(resp.Header.Get("Expires"))
+	if err != nil {
+		expires = time.Now().Add(pushExpiry)
+	}
+	return registrationURL, expires, nil
+}
+
+// doWebDAVRequest sends a request to ref, resolved relative to the collection
+// URL, authenticating with the credentials in the collection URL
+func doWebDAVRequest(ctx context.Context, method string, collectionURL string, ref string, body io.Reader) (*http.Response, error) {
+	base, err := url.Parse(collectionURL)
+	if err != nil {
+		return nil, err
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	requestURL := base.ResolveReference(refURL)
+	requestURL.User = nil
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	}
+	if method == "PROPFIND" {
+		req.Header.Set("Depth", "0")
+	}
+	if base.User != nil && requestURL.Host == base.Host {
+		password, _ := base.User.Password()
+		req.SetBasicAuth(base.User.Username(), password)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// handleRosterPush receives WebDAV-Push messages sent to the push resource
+// of a user's address book, and synchronizes the address book
+func (service *Service) handleRosterPush(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "405 Method Not Allowed: use POST to push", 405)
+		return
+	}
+	token := strings.TrimPrefix(req.URL.Path, "/roster-push/")
+
+	service.mu.RLock()
+	var found *rosterUser
+	for _, user := range service.rosterUsers {
+		if subtle.ConstantTimeCompare([]byte(user.pushToken), []byte(token)) == 1 {
+			found = user
+		}
+	}
+	service.mu.RUnlock()
+
+	if found == nil {
+		http.Error(w, "404 Not Found: unknown push resource", 404)
+		return
+	}
+	found.forceSync()
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleRosterSync synchronizes the address book of the user named by the
+// jid form value, or of every user if it's omitted
+func (service *Service) handleRosterSync(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "405 Method Not Allowed: use POST to synchronize address books", 405)
+		return
+	}
+
+	if jid := req.FormValue("jid"); jid != "" {
+		address, err := xmpp.ParseAddress(jid)
+		if err != nil {
+			http.Error(w, "400 Bad Request: malformed JID: "+err.Error(), 400)
+			return
+		}
+		user, exists := service.lookupRosterUser(address)
+		if !exists {
+			http.Error(w, "404 Not Found: "+jid+" does not have a roster", 404)
+			return
+		}
+		user.forceSync()
+	} else {
+		service.mu.RLock()
+		for _, user := range service.rosterUsers {
+			user.forceSync()
+		}
+		service.mu.RUnlock()
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(200)
+	fmt.Fprintln(w, "Address book synchronization started.")
+}